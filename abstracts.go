@@ -15,13 +15,11 @@ package main
  * See the License for the specific language governing permissions and
  * limitations under the License.
  *
- * abstracts.go: Functions for managing abstracts in the Cassandra database.
+ * abstracts.go: types for abstracts and their scores.
  *
  */
 
 import (
-	"errors"
-	"fmt"
 	"github.com/gocql/gocql"
 	"time"
 )
@@ -31,6 +29,20 @@ type Email string
 type Score float32
 type Authors map[Email]string
 type Scores map[Email]Score
+type CriteriaScores map[string]Scores // rubric criterion name -> scores
+
+// where an abstract is in the decision workflow, see status.go
+type Status string
+
+const (
+	StatusSubmitted   Status = "submitted"
+	StatusUnderReview Status = "under_review"
+	StatusAccepted    Status = "accepted"
+	StatusWaitlisted  Status = "waitlisted"
+	StatusRejected    Status = "rejected"
+	StatusConfirmed   Status = "confirmed"
+	StatusWithdrawn   Status = "withdrawn"
+)
 
 type Abstract struct {
 	Id         gocql.UUID `json:"id"`
@@ -43,15 +55,26 @@ type Abstract struct {
 	JobTitle   string     `json:"jobtitle"`
 	Bio        string     `json:"bio"`
 	Tracks     string     `json:"tracks"`
-
-	// 7 slots for scoring, I don't know what these mean and there's
-	// no point to encoding that meaning here so the 7 note scale it is
-	// examples being tossed around:
-	// the weights would be handled in javascript since security isn't
-	// an issue and it isn't stored anyways
-	// Estimated Skill Level of Speaker — 35% Weight
-	// Quality of Abstract —  40% Weight
-	// Relevance of Topic — 25% Weight
+	TrackIds   []string   `json:"track_ids"` // the tracks table, see tracks.go; Tracks has their names
+	Tags       []Tag      `json:"tags"`      // only changed through AddTag/RemoveTag, see tags.go
+	Version    int        `json:"version"`   // bumped by every edit, sent as the ETag
+
+	// decision workflow, see status.go
+	Status        Status    `json:"status"`
+	StatusBy      string    `json:"status_by"`
+	StatusChanged time.Time `json:"status_changed"`
+
+	// soft delete, see trash.go
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+
+	// every score for every rubric criterion, see rubric.go
+	// these live in their own table and are only written by SaveScore
+	Scores CriteriaScores `json:"scores"`
+
+	// the original 7 fixed scoring slots, kept so existing data and
+	// the UI keep working. On read these are filled in from Scores for
+	// criteria that happen to be named scores_a..scores_g.
 	ScoresA Scores `json:"scores_a"`
 	ScoresB Scores `json:"scores_b"`
 	ScoresC Scores `json:"scores_c"`
@@ -71,107 +94,133 @@ type Abstracts []Abstract
 
 // used to send a single score update (from UI sliders)
 // { "id": "deadbeef-...", "slot": "scores_a", "email": "atobey@datastax.com", "score": 100 }
+// email is optional and defaults to the logged-in user, admins can set
+// it to someone else along with "on_behalf": true, see ScoreUpdateHandler
 type ScoreUpdate struct {
-	Id    gocql.UUID `json:"id"`
-	Slot  string     `json:"slot"`
-	Email Email      `json:"email"`
-	Score Score      `json:"score"`
+	Id       gocql.UUID `json:"id"`
+	Slot     string     `json:"slot"`
+	Email    Email      `json:"email"`
+	Score    Score      `json:"score"`
+	OnBehalf bool       `json:"on_behalf,omitempty"`
 }
 
 type ScoreUpdates []ScoreUpdate
 
-func ListAbstracts(cass *gocql.Session) (Abstracts, error) {
-	alist := make(Abstracts, 0)
-
-	iq := cass.Query(`
-SELECT id, upstream_id, title, body, created, authors,
-       company, jobtitle, bio, tracks,
-       scores_a, scores_b, scores_c, scores_d,
-	   scores_e, scores_f, scores_g, scores_names
-FROM abstracts`).Iter()
-
-	for {
-		a := Abstract{}
-
-		ok := iq.Scan(
-			&a.Id, &a.UpstreamId, &a.Title, &a.Body, &a.Created, &a.Authors,
-			&a.Company, &a.JobTitle, &a.Bio, &a.Tracks,
-			&a.ScoresA, &a.ScoresB, &a.ScoresC, &a.ScoresD,
-			&a.ScoresE, &a.ScoresF, &a.ScoresG, &a.ScoresNames,
-		)
-
-		if ok {
-			alist = append(alist, a)
-		} else {
-			break
+// a record of an admin scoring on behalf of a reviewer
+type ScoreAudit struct {
+	AbsId   gocql.UUID `json:"abstract_id"`
+	Id      gocql.UUID `json:"id"` // timeuuid
+	Created time.Time  `json:"created"`
+	Actor   Email      `json:"actor"` // the admin who did it
+	Email   Email      `json:"email"` // who the score is attributed to
+	Slot    string     `json:"slot"`
+	Score   Score      `json:"score"`
+}
+
+type ScoreAudits []ScoreAudit
+
+var legacySlots = []string{"scores_a", "scores_b", "scores_c", "scores_d", "scores_e", "scores_f", "scores_g"}
+
+// returns a pointer to the scores map for one of the legacy slots
+func (a *Abstract) slotScores(slot string) *Scores {
+	switch slot {
+	case "scores_a":
+		return &a.ScoresA
+	case "scores_b":
+		return &a.ScoresB
+	case "scores_c":
+		return &a.ScoresC
+	case "scores_d":
+		return &a.ScoresD
+	case "scores_e":
+		return &a.ScoresE
+	case "scores_f":
+		return &a.ScoresF
+	case "scores_g":
+		return &a.ScoresG
+	}
+	return nil
+}
+
+// copy makes a deep copy of the abstract so stores that keep
+// them in memory don't hand out shared maps
+func (a Abstract) copy() Abstract {
+	if a.Authors != nil {
+		authors := make(Authors, len(a.Authors))
+		for k, v := range a.Authors {
+			authors[k] = v
 		}
+		a.Authors = authors
 	}
-	if err := iq.Close(); err != nil {
-		return nil, err
+
+	if a.TrackIds != nil {
+		a.TrackIds = append([]string{}, a.TrackIds...)
 	}
 
-	return alist, nil
-}
+	for _, s := range []*Scores{&a.ScoresA, &a.ScoresB, &a.ScoresC, &a.ScoresD, &a.ScoresE, &a.ScoresF, &a.ScoresG} {
+		*s = s.copy()
+	}
 
-func FetchAbstract(cass *gocql.Session, id gocql.UUID) (a Abstract, err error) {
-	q := cass.Query(`
-SELECT id, upstream_id, title, body, created, authors,
-       company, jobtitle, bio, tracks,
-       scores_a, scores_b, scores_c, scores_d,
-	   scores_e, scores_f, scores_g, scores_names
-FROM abstracts WHERE id=?`, id)
-
-	err = q.Scan(
-		&a.Id, &a.UpstreamId, &a.Title, &a.Body, &a.Created, &a.Authors,
-		&a.Company, &a.JobTitle, &a.Bio, &a.Tracks,
-		&a.ScoresA, &a.ScoresB, &a.ScoresC, &a.ScoresD,
-		&a.ScoresE, &a.ScoresF, &a.ScoresG, &a.ScoresNames,
-	)
-
-	return a, err
-}
+	a.Scores = a.Scores.copy()
 
-func DeleteAbstract(cass *gocql.Session, id gocql.UUID) (err error) {
-	return cass.Query(`DELETE FROM abstracts WHERE id=?`, &id).Exec()
-}
+	if a.ScoresNames != nil {
+		names := make(map[string]string, len(a.ScoresNames))
+		for k, v := range a.ScoresNames {
+			names[k] = v
+		}
+		a.ScoresNames = names
+	}
 
-// Create a new abstract record in the DB.
-// Scores are written through ScoreUpdate.Save() and are
-// not expected to be overwritten by this call.
-func (a *Abstract) Save(cass *gocql.Session) error {
-	return cass.Query(`
-INSERT INTO abstracts (
-       id, upstream_id, title, body, created, authors,
-       company, jobtitle, bio, tracks
-	)
-VALUES
-    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		&a.Id, &a.UpstreamId, &a.Title,
-		&a.Body, &a.Created, &a.Authors,
-		&a.Company, &a.JobTitle, &a.Bio,
-		&a.Tracks,
-	).Exec()
+	return a
 }
 
-func (su *ScoreUpdate) Save(cass *gocql.Session) error {
-	var query string // for untaint
+func (s Scores) copy() Scores {
+	if s == nil {
+		return nil
+	}
+	out := make(Scores, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	return out
+}
 
-	switch su.Slot {
-	case "scores_a", "scores_b", "scores_c", "scores_d", "scores_e", "scores_f", "scores_g":
-		query = fmt.Sprintf("UPDATE abstracts SET %s[?] = ? WHERE id=?", su.Slot)
-	default:
-		return errors.New("Invalid score slot in input.")
+func (cs CriteriaScores) copy() CriteriaScores {
+	if cs == nil {
+		return nil
+	}
+	out := make(CriteriaScores, len(cs))
+	for k, v := range cs {
+		out[k] = v.copy()
 	}
+	return out
+}
 
-	return cass.Query(query, su.Email, su.Score, su.Id).Exec()
+func (cs CriteriaScores) set(criterion string, email Email, score Score) {
+	if cs[criterion] == nil {
+		cs[criterion] = make(Scores)
+	}
+	cs[criterion][email] = score
 }
 
-func (scores ScoreUpdates) Save(cass *gocql.Session) (err error) {
-	for _, su := range scores {
-		err = su.Save(cass)
-		if err != nil {
-			break
+// setScores attaches scores from the store, merging in anything still
+// in the legacy slot columns (newer scores win), then fills the legacy
+// slots back in so older clients that only know scores_a..g keep working
+func (a *Abstract) setScores(cs CriteriaScores) {
+	merged := make(CriteriaScores)
+	for _, slot := range legacySlots {
+		for email, score := range *a.slotScores(slot) {
+			merged.set(slot, email, score)
+		}
+	}
+	for criterion, scores := range cs {
+		for email, score := range scores {
+			merged.set(criterion, email, score)
 		}
 	}
-	return
+
+	a.Scores = merged
+	for _, slot := range legacySlots {
+		*a.slotScores(slot) = merged[slot].copy()
+	}
 }
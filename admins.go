@@ -15,36 +15,22 @@ package main
  * See the License for the specific language governing permissions and
  * limitations under the License.
  *
- * admins.go: manage the admin list in cassandra
+ * admins.go: manage the admin list
  *
  */
 
 import (
 	"errors"
+	"net/mail"
+	"strings"
 )
 
 type Admins []string
 
-func fetchAdmins() (Admins, error) {
-	alist := make(Admins, 0)
-
-	iq := cass.Query(`SELECT email FROM admins`).Iter()
-
-	for {
-		admin := ""
-		ok := iq.Scan(&admin)
-
-		if ok {
-			alist = append(alist, admin)
-		} else {
-			break
-		}
-	}
-	if err := iq.Close(); err != nil {
-		return nil, err
-	}
+var ErrLastAdmin = errors.New("refusing to remove the last admin")
 
-	return alist, nil
+func fetchAdmins() (Admins, error) {
+	return db.ListAdmins()
 }
 
 func checkIfAdmin(email string) (bool, error) {
@@ -58,10 +44,50 @@ func checkIfAdmin(email string) (bool, error) {
 	}
 
 	for _, a := range admins {
-		if email == a {
+		if normalizeEmail(a) == normalizeEmail(email) {
 			return true, nil
 		}
 	}
 
 	return false, nil
 }
+
+// removeAdmin deletes an admin, but never the last one since there'd
+// be nobody left to fix it without raw CQL
+func removeAdmin(email string) error {
+	admins, err := fetchAdmins()
+	if err != nil {
+		return err
+	}
+
+	// the row might be from before emails were lowercased
+	stored := ""
+	for _, a := range admins {
+		if normalizeEmail(a) == normalizeEmail(email) {
+			stored = a
+		}
+	}
+	if stored == "" {
+		return ErrNotFound
+	}
+	if len(admins) == 1 {
+		return ErrLastAdmin
+	}
+
+	return db.DeleteAdmin(stored)
+}
+
+// emails are compared and stored lowercased everywhere, IdPs and
+// people don't agree on case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// cleans up an email address from user input
+func parseEmail(input string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(input))
+	if err != nil {
+		return "", err
+	}
+	return normalizeEmail(addr.Address), nil
+}
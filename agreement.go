@@ -0,0 +1,299 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * agreement.go: how much the reviewers agree with each other
+ *
+ * All of these work on one rubric criterion at a time and only look at
+ * abstracts with at least two scores, since one score can't disagree.
+ * Reviewers don't all score every abstract, so Fleiss' kappa uses the
+ * variable raters-per-item form and Krippendorff's alpha handles the
+ * missing data on its own. Statistics that are undefined (e.g. everyone
+ * gave the same score) come out as null.
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type PairAgreement struct {
+	Shared   int     `json:"shared"`    // abstracts both reviewers scored
+	Exact    float64 `json:"exact"`     // fraction of those with the same score
+	MeanDiff float64 `json:"mean_diff"` // mean absolute difference
+}
+
+type ContestedAbstract struct {
+	Id     gocql.UUID `json:"id"`
+	Title  string     `json:"title"`
+	Count  int        `json:"count"`
+	Min    Score      `json:"min"`
+	Max    Score      `json:"max"`
+	StdDev float64    `json:"stddev"`
+}
+
+type Agreement struct {
+	Criterion     string                            `json:"criterion"`
+	Abstracts     int                               `json:"abstracts"` // with 2+ scores
+	Ratings       int                               `json:"ratings"`   // scores on those abstracts
+	FleissKappa   *float64                          `json:"fleiss_kappa"`
+	AlphaNominal  *float64                          `json:"alpha_nominal"`
+	AlphaInterval *float64                          `json:"alpha_interval"`
+	Pairwise      map[Email]map[Email]PairAgreement `json:"pairwise"`
+	Contested     []ContestedAbstract               `json:"contested"`
+}
+
+// for JSON nulls
+func optFloat(v float64, ok bool) *float64 {
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// fleissKappa treats each distinct score as a category
+func fleissKappa(units []Scores) (float64, bool) {
+	totals := make(map[Score]float64)
+	sumP, sumN := 0.0, 0.0
+	for _, u := range units {
+		n := float64(len(u))
+		counts := make(map[Score]float64)
+		for _, s := range u {
+			counts[s]++
+		}
+		agree := 0.0
+		for score, c := range counts {
+			agree += c * (c - 1)
+			totals[score] += c
+		}
+		sumP += agree / (n * (n - 1))
+		sumN += n
+	}
+	if len(units) == 0 {
+		return 0, false
+	}
+
+	pBar := sumP / float64(len(units))
+	pE := 0.0
+	for _, c := range totals {
+		p := c / sumN
+		pE += p * p
+	}
+	if pE == 1 {
+		return 0, false
+	}
+
+	return (pBar - pE) / (1 - pE), true
+}
+
+// sum of the distance over every ordered pair of values, computed from
+// counts/sums instead of looping over the pairs
+func nominalDisagreement(values []float64) float64 {
+	counts := make(map[float64]float64)
+	for _, v := range values {
+		counts[v]++
+	}
+	n := float64(len(values))
+	same := 0.0
+	for _, c := range counts {
+		same += c * c
+	}
+	return n*n - same
+}
+
+func intervalDisagreement(values []float64) float64 {
+	sum, sumSq := 0.0, 0.0
+	for _, v := range values {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(values))
+	return 2*n*sumSq - 2*sum*sum
+}
+
+// krippendorffAlpha is 1 - observed/expected disagreement, where the
+// observed disagreement is within abstracts and the expected is across
+// all the scores regardless of abstract
+func krippendorffAlpha(units []Scores, delta func([]float64) float64) (float64, bool) {
+	all := make([]float64, 0)
+	observed := 0.0
+	for _, u := range units {
+		values := u.values()
+		observed += delta(values) / float64(len(values)-1)
+		all = append(all, values...)
+	}
+
+	n := float64(len(all))
+	if n < 2 {
+		return 0, false
+	}
+
+	expected := delta(all) / (n - 1)
+	if expected == 0 {
+		return 0, false
+	}
+
+	return 1 - observed/expected, true
+}
+
+func pairwiseAgreement(units []Scores) map[Email]map[Email]PairAgreement {
+	type tally struct{ shared, exact, diff float64 }
+	tallies := make(map[Email]map[Email]*tally)
+
+	for _, u := range units {
+		for a, sa := range u {
+			for b, sb := range u {
+				if a == b {
+					continue
+				}
+				if tallies[a] == nil {
+					tallies[a] = make(map[Email]*tally)
+				}
+				t := tallies[a][b]
+				if t == nil {
+					t = &tally{}
+					tallies[a][b] = t
+				}
+				t.shared++
+				if sa == sb {
+					t.exact++
+				}
+				if sa > sb {
+					t.diff += float64(sa - sb)
+				} else {
+					t.diff += float64(sb - sa)
+				}
+			}
+		}
+	}
+
+	out := make(map[Email]map[Email]PairAgreement, len(tallies))
+	for a, row := range tallies {
+		out[a] = make(map[Email]PairAgreement, len(row))
+		for b, t := range row {
+			out[a][b] = PairAgreement{
+				Shared:   int(t.shared),
+				Exact:    t.exact / t.shared,
+				MeanDiff: t.diff / t.shared,
+			}
+		}
+	}
+
+	return out
+}
+
+// computeAgreement works on the given criterion, contested lists the
+// limit abstracts with the largest spread
+func computeAgreement(alist Abstracts, criterion string, limit int) Agreement {
+	ag := Agreement{Criterion: criterion, Contested: make([]ContestedAbstract, 0)}
+
+	units := make([]Scores, 0)
+	for _, a := range alist {
+		scores := a.Scores[criterion]
+		if len(scores) < 2 {
+			continue
+		}
+		units = append(units, scores)
+		ag.Ratings += len(scores)
+
+		stats := computeStats(scores.values())
+		ca := ContestedAbstract{Id: a.Id, Title: a.Title, Count: stats.Count, StdDev: stats.StdDev}
+		first := true
+		for _, s := range scores {
+			if first || s < ca.Min {
+				ca.Min = s
+			}
+			if first || s > ca.Max {
+				ca.Max = s
+			}
+			first = false
+		}
+		ag.Contested = append(ag.Contested, ca)
+	}
+	ag.Abstracts = len(units)
+
+	ag.FleissKappa = optFloat(fleissKappa(units))
+	ag.AlphaNominal = optFloat(krippendorffAlpha(units, nominalDisagreement))
+	ag.AlphaInterval = optFloat(krippendorffAlpha(units, intervalDisagreement))
+	ag.Pairwise = pairwiseAgreement(units)
+
+	sort.SliceStable(ag.Contested, func(i, j int) bool {
+		return ag.Contested[i].StdDev > ag.Contested[j].StdDev
+	})
+	if len(ag.Contested) > limit {
+		ag.Contested = ag.Contested[:limit]
+	}
+
+	return ag
+}
+
+// GET /agreement/?criterion=scores_a&limit=10
+// criterion defaults to the first one in the rubric
+// this names reviewers, so it's limited to people who can edit
+func AgreementHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermEdit) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 10
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit '%s'", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("AgreementHandler failed to load the rubric: %s", err), 500)
+		return
+	}
+
+	criterion := r.FormValue("criterion")
+	if criterion == "" && len(rubric) > 0 {
+		criterion = rubric[0].Name
+	}
+	if _, ok := rubric.Get(criterion); !ok {
+		http.Error(w, fmt.Sprintf("'%s' is not a scoring criterion", criterion), http.StatusNotFound)
+		return
+	}
+
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("AgreementHandler failed to list abstracts: %s", err), 500)
+		return
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("AgreementHandler failed to load conflicts: %s", err), 500)
+		return
+	}
+	alist = dropConflicted(alist, ci)
+
+	jsonOut(w, r, computeAgreement(alist, criterion, limit))
+}
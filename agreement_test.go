@@ -0,0 +1,164 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * agreement_test.go: kappa and alpha against published worked examples
+ *
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// reliability turns one line per coder, with . for a missing score, into
+// units the way computeAgreement does, dropping any with fewer than 2
+func reliability(coders ...string) []Scores {
+	units := make([]Scores, 0)
+	for i, line := range coders {
+		for j, field := range strings.Fields(line) {
+			for len(units) <= j {
+				units = append(units, make(Scores))
+			}
+			if v, err := strconv.Atoi(field); err == nil {
+				units[j][Email(fmt.Sprintf("c%d", i))] = Score(v)
+			}
+		}
+	}
+
+	out := make([]Scores, 0, len(units))
+	for _, u := range units {
+		if len(u) >= 2 {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// categorized turns a row of how many raters picked each category into
+// a unit, category i is score i+1
+func categorized(rows ...[]int) []Scores {
+	units := make([]Scores, 0, len(rows))
+	for _, row := range rows {
+		u := make(Scores)
+		for cat, n := range row {
+			for i := 0; i < n; i++ {
+				u[Email(fmt.Sprintf("r%d", len(u)))] = Score(cat + 1)
+			}
+		}
+		units = append(units, u)
+	}
+	return units
+}
+
+func TestFleissKappa(t *testing.T) {
+	tests := []struct {
+		name  string
+		units []Scores
+		want  float64
+		ok    bool
+	}{
+		// Fleiss (1971) as worked on Wikipedia: 10 subjects, 14 raters,
+		// 5 categories, P = 0.378, Pe = 0.213, kappa = 0.210
+		{"fleiss 1971", categorized(
+			[]int{0, 0, 0, 0, 14},
+			[]int{0, 2, 6, 4, 2},
+			[]int{0, 0, 3, 5, 6},
+			[]int{0, 3, 9, 2, 0},
+			[]int{2, 2, 8, 1, 1},
+			[]int{7, 7, 0, 0, 0},
+			[]int{3, 2, 6, 3, 0},
+			[]int{2, 5, 3, 2, 2},
+			[]int{6, 5, 2, 1, 0},
+			[]int{0, 2, 2, 3, 7},
+		), 0.210, true},
+		{"perfect", categorized([]int{2, 0}, []int{0, 2}), 1, true},
+		{"always split", categorized([]int{1, 1}, []int{1, 1}), -1, true},
+		{"everyone gave the same score", categorized([]int{3}, []int{2}), 0, false},
+		{"nothing", []Scores{}, 0, false},
+	}
+
+	for _, tc := range tests {
+		got, ok := fleissKappa(tc.units)
+		if ok != tc.ok || math.Abs(got-tc.want) > 0.0005 {
+			t.Errorf("%s: fleissKappa = %v %v, want %v %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestKrippendorffAlpha(t *testing.T) {
+	// Krippendorff (2011) "Computing Krippendorff's Alpha-Reliability",
+	// 4 coders, 12 units with missing data: nominal 0.743, interval 0.849
+	worked := reliability(
+		"1 2 3 3 2 1 4 1 2 . . .",
+		"1 2 3 3 2 2 4 1 2 5 . 3",
+		". 3 3 3 2 3 4 2 2 5 1 .",
+		"1 2 3 3 2 4 4 1 2 5 1 .",
+	)
+	if len(worked) != 11 {
+		t.Fatalf("worked example has %d pairable units, want 11", len(worked))
+	}
+
+	tests := []struct {
+		name  string
+		units []Scores
+		delta func([]float64) float64
+		want  float64
+		ok    bool
+	}{
+		{"worked nominal", worked, nominalDisagreement, 0.743, true},
+		{"worked interval", worked, intervalDisagreement, 0.849, true},
+		{"perfect", reliability("1 2 3", "1 2 3"), intervalDisagreement, 1, true},
+		{"everyone gave the same score", reliability("4 4", "4 4"), nominalDisagreement, 0, false},
+		{"nothing", []Scores{}, nominalDisagreement, 0, false},
+	}
+
+	for _, tc := range tests {
+		got, ok := krippendorffAlpha(tc.units, tc.delta)
+		if ok != tc.ok || math.Abs(got-tc.want) > 0.0005 {
+			t.Errorf("%s: krippendorffAlpha = %v %v, want %v %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestComputeAgreement(t *testing.T) {
+	alist := Abstracts{
+		scored("split", CriteriaScores{"q": Scores{"a": 1, "b": 3}}),
+		scored("agreed", CriteriaScores{"q": Scores{"a": 2, "b": 2}}),
+		scored("lonely", CriteriaScores{"q": Scores{"a": 5}}),
+	}
+
+	ag := computeAgreement(alist, "q", 1)
+	if ag.Abstracts != 2 || ag.Ratings != 4 {
+		t.Errorf("Abstracts, Ratings = %d, %d, want 2, 4", ag.Abstracts, ag.Ratings)
+	}
+	if len(ag.Contested) != 1 || ag.Contested[0].Title != "split" || ag.Contested[0].Min != 1 || ag.Contested[0].Max != 3 {
+		t.Errorf("Contested = %+v", ag.Contested)
+	}
+	if pa := ag.Pairwise["a"]["b"]; pa.Shared != 2 || pa.Exact != 0.5 || pa.MeanDiff != 1 {
+		t.Errorf("Pairwise[a][b] = %+v", pa)
+	}
+	if ag.FleissKappa == nil || ag.AlphaNominal == nil || ag.AlphaInterval == nil {
+		t.Errorf("statistics left out: %+v", ag)
+	}
+
+	if ag := computeAgreement(alist, "unscored", 10); ag.FleissKappa != nil || ag.AlphaNominal != nil || len(ag.Contested) != 0 {
+		t.Errorf("computeAgreement with no scores = %+v", ag)
+	}
+}
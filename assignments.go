@@ -0,0 +1,369 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * assignments.go: handing out abstracts to reviewers
+ *
+ * The engine tops every abstract up to a target number of reviewers,
+ * neediest abstracts first. For each open spot it picks the eligible
+ * reviewer with the lightest load, where a reviewer whose tracks match
+ * the abstract counts as having one less assignment than they do.
+ * Reviewers with a conflict of interest are skipped, see conflicts.go.
+ * Existing assignments are never moved, so it's safe to rerun after
+ * new abstracts or reviewers show up.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type Assignment struct {
+	AbsId    gocql.UUID `json:"abstract_id"`
+	Email    string     `json:"email"`
+	Assigned time.Time  `json:"assigned"`
+	By       string     `json:"assigned_by"` // who assigned it, "auto" for the engine
+}
+
+type Assignments []Assignment
+
+// settings for a run of the engine, from the POST body
+type AssignOptions struct {
+	PerAbstract int  `json:"per_abstract"` // target reviewers per abstract
+	MaxLoad     int  `json:"max_load"`     // most abstracts per reviewer, 0 for no limit
+	DryRun      bool `json:"dry_run"`      // return what would be assigned without saving
+}
+
+// an entry in a reviewer's queue
+type QueueItem struct {
+	AbsId    gocql.UUID `json:"abstract_id"`
+	Title    string     `json:"title"`
+	Tracks   string     `json:"tracks"`
+	Assigned time.Time  `json:"assigned"`
+	Done     bool       `json:"done"` // every rubric criterion has been scored
+}
+
+// trackMatch is true if any of the abstract's tracks is one the reviewer
+// asked for, by name or id
+func trackMatch(rev *Reviewer, a *Abstract) bool {
+	u := User{Tracks: rev.Tracks}
+	for _, t := range append(splitTracks(a.Tracks), a.TrackIds...) {
+		if u.hasTrack(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// assign is the engine, it returns only the new assignments
+func assign(alist Abstracts, reviewers []Reviewer, existing Assignments, ci *conflictIndex, opts AssignOptions) Assignments {
+	// only people who can score get assignments
+	eligible := make([]Reviewer, 0, len(reviewers))
+	for _, rev := range reviewers {
+		u := User{Role: rev.Role}
+		if u.Role == RoleNone {
+			u.Role = RoleReviewer
+		}
+		if u.Can(PermScore) {
+			eligible = append(eligible, rev)
+		}
+	}
+
+	for i := range eligible {
+		eligible[i].Email = normalizeEmail(eligible[i].Email)
+	}
+
+	load := make(map[string]int)
+	have := make(map[gocql.UUID]map[string]bool)
+	for _, as := range existing {
+		email := normalizeEmail(as.Email)
+		load[email]++
+		if have[as.AbsId] == nil {
+			have[as.AbsId] = make(map[string]bool)
+		}
+		have[as.AbsId][email] = true
+	}
+
+	order := make([]int, len(alist))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(have[alist[order[i]].Id]) < len(have[alist[order[j]].Id])
+	})
+
+	now := time.Now()
+	added := make(Assignments, 0)
+	for _, i := range order {
+		a := &alist[i]
+		if have[a.Id] == nil {
+			have[a.Id] = make(map[string]bool)
+		}
+
+		for len(have[a.Id]) < opts.PerAbstract {
+			best, bestCost := -1, 0
+			for j := range eligible {
+				rev := &eligible[j]
+				if have[a.Id][rev.Email] {
+					continue
+				}
+				if _, ok := ci.check(rev.Email, a); ok {
+					continue
+				}
+				if opts.MaxLoad > 0 && load[rev.Email] >= opts.MaxLoad {
+					continue
+				}
+
+				cost := load[rev.Email]
+				if trackMatch(rev, a) {
+					cost--
+				}
+				if best == -1 || cost < bestCost || (cost == bestCost && rev.Email < eligible[best].Email) {
+					best, bestCost = j, cost
+				}
+			}
+			if best == -1 {
+				break // nobody left for this one
+			}
+
+			email := eligible[best].Email
+			have[a.Id][email] = true
+			load[email]++
+			added = append(added, Assignment{AbsId: a.Id, Email: email, Assigned: now, By: "auto"})
+		}
+	}
+
+	return added
+}
+
+// GET lists all assignments, POST runs the engine with AssignOptions
+func AssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		alist, err := db.ListAssignments()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentsHandler/GET failed: %s", err), 500)
+			return
+		}
+		jsonOut(w, r, alist)
+	case "POST":
+		opts := AssignOptions{PerAbstract: 3}
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&opts)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("AssignmentsHandler/POST invalid json data: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if opts.PerAbstract < 1 || opts.MaxLoad < 0 {
+			http.Error(w, "per_abstract must be at least 1 and max_load can't be negative", http.StatusBadRequest)
+			return
+		}
+
+		abstracts, err := db.ListAbstracts()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentsHandler/POST failed to list abstracts: %s", err), 500)
+			return
+		}
+		reviewers, err := db.ListReviewers()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentsHandler/POST failed to list reviewers: %s", err), 500)
+			return
+		}
+		existing, err := db.ListAssignments()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentsHandler/POST failed to list assignments: %s", err), 500)
+			return
+		}
+		ci, err := loadConflictIndex()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentsHandler/POST failed to load conflicts: %s", err), 500)
+			return
+		}
+
+		added := assign(abstracts, reviewers, existing, ci, opts)
+		if !opts.DryRun {
+			for _, as := range added {
+				err = db.SaveAssignment(&as)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("AssignmentsHandler/POST failed: %s", err), 500)
+					return
+				}
+			}
+			log.Printf("AssignmentsHandler: '%s' made %d assignments\n", u.Email, len(added))
+		}
+
+		jsonOut(w, r, added)
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// PUT assigns one abstract to one reviewer by hand, DELETE unassigns
+func AssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := gocql.ParseUUID(vars["abstract_id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+	email, err := parseEmail(vars["email"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid email address: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		rev, err := lookupUser(email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentHandler/PUT failed: %s", err), 500)
+			return
+		}
+		if rev.Role == RoleNone {
+			http.Error(w, fmt.Sprintf("'%s' isn't an admin or a reviewer", email), http.StatusBadRequest)
+			return
+		}
+		if !rev.Can(PermScore) {
+			http.Error(w, fmt.Sprintf("'%s' is a %s and can't score abstracts", email, rev.Role), http.StatusConflict)
+			return
+		}
+
+		a, err := db.FetchAbstract(id)
+		if err == ErrNotFound {
+			http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentHandler/PUT failed: %s", err), 500)
+			return
+		}
+		_, conflicted, err := checkConflict(email, &a)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentHandler/PUT failed to check conflicts: %s", err), 500)
+			return
+		}
+		if conflicted {
+			http.Error(w, fmt.Sprintf("'%s' has a conflict of interest with this abstract", email), http.StatusConflict)
+			return
+		}
+
+		as := Assignment{AbsId: id, Email: email, Assigned: time.Now(), By: u.Email}
+		err = db.SaveAssignment(&as)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("AssignmentHandler: '%s' assigned %s to '%s'\n", u.Email, id, email)
+		jsonOut(w, r, as)
+	case "DELETE":
+		err = db.DeleteAssignment(email, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AssignmentHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("AssignmentHandler: '%s' unassigned %s from '%s'\n", u.Email, id, email)
+		jsonOut(w, r, map[string]string{"abstract_id": id.String(), "email": email})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// GET returns the logged-in reviewer's assignments, unfinished first
+func QueueHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermScore)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("QueueHandler failed to load the rubric: %s", err), 500)
+		return
+	}
+
+	alist, err := db.ListReviewerAssignments(u.Email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("QueueHandler failed: %s", err), 500)
+		return
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("QueueHandler failed to load conflicts: %s", err), 500)
+		return
+	}
+
+	queue := make([]QueueItem, 0, len(alist))
+	for _, as := range alist {
+		a, err := db.FetchAbstract(as.AbsId)
+		if err == ErrNotFound {
+			continue // deleted since it was assigned
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("QueueHandler failed: %s", err), 500)
+			return
+		}
+		if _, conflicted := ci.check(u.Email, &a); conflicted {
+			continue // declared or found after it was assigned
+		}
+
+		done := true
+		for _, c := range rubric {
+			if _, ok := a.Scores[c.Name][Email(u.Email)]; !ok {
+				done = false
+				break
+			}
+		}
+
+		queue = append(queue, QueueItem{
+			AbsId:    a.Id,
+			Title:    a.Title,
+			Tracks:   a.Tracks,
+			Assigned: as.Assigned,
+			Done:     done,
+		})
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		if queue[i].Done != queue[j].Done {
+			return !queue[i].Done
+		}
+		return queue[i].Assigned.Before(queue[j].Assigned)
+	})
+
+	jsonOut(w, r, queue)
+}
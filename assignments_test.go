@@ -0,0 +1,80 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * assignments_test.go: manual assignments and the review queue
+ *
+ */
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestAssignmentHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	ms.SaveReviewer(&Reviewer{Email: "obs@x", Role: RoleObserver})
+	ms.SaveReviewer(&Reviewer{Email: "friend@x"})
+	id := gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: id, Title: "t"})
+	ms.SaveConflict(&Conflict{AbsId: id, Email: "friend@x", Source: ConflictManual})
+	h, adm := newRouter(), loginAs(t, "adm@x")
+	path := "/assignments/" + id.String() + "/"
+
+	tests := []struct {
+		email string
+		code  int
+	}{
+		{"rev@x", 200},
+		{"Rev@X", 200},
+		{"adm@x", 200},
+		{"nobody@x", 400},
+		{"obs@x", 409},
+		{"friend@x", 409},
+	}
+	for _, tc := range tests {
+		if rec := do(t, h, adm, "PUT", path+tc.email, ""); rec.Code != tc.code {
+			t.Errorf("PUT %s%s = %d, want %d: %s", path, tc.email, rec.Code, tc.code, rec.Body.String())
+		}
+	}
+
+	if all, _ := ms.ListAssignments(); len(all) != 2 {
+		t.Errorf("assignments after the PUTs = %+v", all)
+	}
+}
+
+func TestQueueConflicts(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	kept, conflicted := gocql.TimeUUID(), gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: kept, Title: "kept"})
+	ms.SaveAbstract(&Abstract{Id: conflicted, Title: "conflicted"})
+	ms.SaveAssignment(&Assignment{AbsId: kept, Email: "rev@x"})
+	ms.SaveAssignment(&Assignment{AbsId: conflicted, Email: "rev@x"})
+	// declared after it was assigned
+	ms.SaveConflict(&Conflict{AbsId: conflicted, Email: "rev@x", Source: ConflictManual})
+
+	rec := do(t, newRouter(), loginAs(t, "rev@x"), "GET", "/queue/", "")
+	queue := []QueueItem{}
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if rec.Code != 200 || len(queue) != 1 || queue[0].AbsId != kept {
+		t.Errorf("GET /queue/ = %d %s", rec.Code, rec.Body.String())
+	}
+}
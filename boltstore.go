@@ -0,0 +1,858 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * boltstore.go: a single-file implementation of Store using bbolt
+ *
+ * For small CFPs that don't have a Cassandra cluster handy. Each table
+ * in schema.cql gets a bucket and rows are stored as JSON. The write
+ * methods mimic CQL upsert behavior so the app can't tell the difference.
+ *
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gocql/gocql"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+var (
+	abstractsBucket = []byte("abstracts")
+	scoresBucket    = []byte("scores")
+	eventsBucket    = []byte("events")
+	rubricBucket    = []byte("rubric")
+	tracksBucket    = []byte("tracks")
+	assignedBucket  = []byte("assignments")
+	conflictsBucket = []byte("conflicts")
+	commentsBucket  = []byte("comments")
+	auditsBucket    = []byte("score_audit")
+	revisionsBucket = []byte("revisions")
+	adminsBucket    = []byte("admins")
+	reviewersBucket = []byte("reviewers")
+	speakersBucket  = []byte("speakers")
+	sessionsBucket  = []byte("sessions")
+	tokensBucket    = []byte("login_tokens")
+)
+
+type BoltStore struct {
+	Bolt *bolt.DB // open bbolt database
+}
+
+// opens (or creates) the database file and makes sure all the
+// buckets exist
+func NewBoltStore(path string) (*BoltStore, error) {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{abstractsBucket, scoresBucket, eventsBucket, rubricBucket, tracksBucket, assignedBucket, conflictsBucket, commentsBucket, auditsBucket, revisionsBucket, adminsBucket, reviewersBucket, speakersBucket, sessionsBucket, tokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	return &BoltStore{Bolt: bdb}, nil
+}
+
+func (bs *BoltStore) Close() error {
+	return bs.Bolt.Close()
+}
+
+// reads a JSON value from the bucket, returns ErrNotFound if the key is missing
+func getJSON(tx *bolt.Tx, bucket, key []byte, v interface{}) error {
+	data := tx.Bucket(bucket).Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func putJSON(tx *bolt.Tx, bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, data)
+}
+
+func (bs *BoltStore) ListAbstracts() (Abstracts, error) {
+	all, err := bs.listAbstracts()
+	return splitTrash(all, false), err
+}
+
+// bolt has no paging state, see pageSorted
+func (bs *BoltStore) PageAbstracts(size int, state []byte) (Abstracts, []byte, error) {
+	alist, err := bs.ListAbstracts()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pageSorted(alist, size, state)
+}
+
+func (bs *BoltStore) ListTrash() (Abstracts, error) {
+	all, err := bs.listAbstracts()
+	return splitTrash(all, true), err
+}
+
+func (bs *BoltStore) listAbstracts() (Abstracts, error) {
+	alist := make(Abstracts, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(abstractsBucket).ForEach(func(k, v []byte) error {
+			a := Abstract{}
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if err := getScores(tx, &a); err != nil {
+				return err
+			}
+			alist = append(alist, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Created.Before(alist[j].Created)
+	})
+
+	return alist, nil
+}
+
+func (bs *BoltStore) FetchAbstract(id gocql.UUID) (Abstract, error) {
+	a, err := bs.fetchAbstract(id)
+	return inTrash(a, err, false)
+}
+
+func (bs *BoltStore) FetchTrash(id gocql.UUID) (Abstract, error) {
+	a, err := bs.fetchAbstract(id)
+	return inTrash(a, err, true)
+}
+
+func (bs *BoltStore) fetchAbstract(id gocql.UUID) (a Abstract, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		err := getJSON(tx, abstractsBucket, id.Bytes(), &a)
+		if err != nil {
+			return err
+		}
+		return getScores(tx, &a)
+	})
+	return
+}
+
+// scores are stored per abstract, like a CQL partition
+// this also fills in the rest of what a read needs
+func getScores(tx *bolt.Tx, a *Abstract) error {
+	cs := make(CriteriaScores)
+	err := getJSON(tx, scoresBucket, a.Id.Bytes(), &cs)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	a.setScores(cs)
+	a.defaultStatus()
+	return nil
+}
+
+// same as the CQL INSERT: scores and status are left alone
+func (bs *BoltStore) SaveAbstract(a *Abstract) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		old := Abstract{}
+		err := getJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		return putAbstract(tx, a, &old)
+	})
+}
+
+// same as the CQL UPDATE ... IF version = ?
+func (bs *BoltStore) UpdateAbstract(a *Abstract, version int) error {
+	err := bs.Bolt.Update(func(tx *bolt.Tx) error {
+		old := Abstract{}
+		err := getJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if old.Version != version {
+			return ErrStale
+		}
+
+		na := *a
+		na.Version = version + 1
+		return putAbstract(tx, &na, &old)
+	})
+	if err == nil {
+		a.Version = version + 1
+	}
+	return err
+}
+
+func putAbstract(tx *bolt.Tx, a, old *Abstract) error {
+	na := *a
+	na.ScoresA, na.ScoresB, na.ScoresC = old.ScoresA, old.ScoresB, old.ScoresC
+	na.ScoresD, na.ScoresE, na.ScoresF = old.ScoresD, old.ScoresE, old.ScoresF
+	na.ScoresG, na.ScoresNames = old.ScoresG, old.ScoresNames
+	na.Scores = nil
+	na.Status, na.StatusBy, na.StatusChanged = old.Status, old.StatusBy, old.StatusChanged
+	na.DeletedBy, na.DeletedAt = old.DeletedBy, old.DeletedAt
+	na.Tags = old.Tags
+
+	return putJSON(tx, abstractsBucket, a.Id.Bytes(), &na)
+}
+
+func (bs *BoltStore) PurgeAbstract(id gocql.UUID) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{commentsBucket, revisionsBucket, auditsBucket, conflictsBucket} {
+			if err := deleteChildren(tx, b, id); err != nil {
+				return err
+			}
+		}
+		if err := deleteAssignments(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Bucket(scoresBucket).Delete(id.Bytes()); err != nil {
+			return err
+		}
+		return tx.Bucket(abstractsBucket).Delete(id.Bytes())
+	})
+}
+
+func (bs *BoltStore) SaveDeleted(a *Abstract) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		old := Abstract{}
+		err := getJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+		if err != nil {
+			return err
+		}
+
+		old.DeletedBy, old.DeletedAt = a.DeletedBy, a.DeletedAt
+
+		return putJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+	})
+}
+
+// same as the CQL UPDATE: creates the row if it doesn't exist
+func (bs *BoltStore) SaveStatus(a *Abstract) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		old := Abstract{Id: a.Id}
+		err := getJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		old.Status, old.StatusBy, old.StatusChanged = a.Status, a.StatusBy, a.StatusChanged
+
+		return putJSON(tx, abstractsBucket, a.Id.Bytes(), &old)
+	})
+}
+
+// merges the score into the abstract's scores, same as the CQL INSERT
+func (bs *BoltStore) SaveScore(su *ScoreUpdate) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		cs := make(CriteriaScores)
+		err := getJSON(tx, scoresBucket, su.Id.Bytes(), &cs)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		cs.set(su.Slot, su.Email, su.Score)
+
+		return putJSON(tx, scoresBucket, su.Id.Bytes(), cs)
+	})
+}
+
+func (bs *BoltStore) ListRevisions(absId gocql.UUID) (Revisions, error) {
+	revs := make(Revisions, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return forEachChild(tx, revisionsBucket, absId, func(v []byte) error {
+			rv := Revision{}
+			if err := json.Unmarshal(v, &rv); err != nil {
+				return err
+			}
+			rv.Created = rv.Id.Time()
+			revs = append(revs, rv)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	revs.sort()
+
+	return revs, nil
+}
+
+func (bs *BoltStore) FetchRevision(absId, id gocql.UUID) (rv Revision, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, revisionsBucket, childKey(absId, id), &rv)
+	})
+	rv.Created = rv.Id.Time()
+	return
+}
+
+func (bs *BoltStore) SaveRevision(rv *Revision) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, revisionsBucket, childKey(rv.AbsId, rv.Id), rv)
+	})
+}
+
+func (bs *BoltStore) FetchEvent(name string) (e Event, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, eventsBucket, []byte(name), &e)
+	})
+	return
+}
+
+func (bs *BoltStore) SaveEvent(e *Event) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, eventsBucket, []byte(e.Name), e)
+	})
+}
+
+// rubric keys are event + NUL + name so an event's criteria are
+// contiguous and can be found with a prefix seek
+func rubricKey(event, name string) []byte {
+	return []byte(event + "\x00" + name)
+}
+
+func (bs *BoltStore) ListRubric(event string) (Rubric, error) {
+	rubric := make(Rubric, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		prefix := rubricKey(event, "")
+		c := tx.Bucket(rubricBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			crit := Criterion{}
+			if err := json.Unmarshal(v, &crit); err != nil {
+				return err
+			}
+			rubric = append(rubric, crit)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rubric, nil
+}
+
+func (bs *BoltStore) SaveCriterion(c *Criterion) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, rubricBucket, rubricKey(c.Event, c.Name), c)
+	})
+}
+
+func (bs *BoltStore) DeleteCriterion(event, name string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(rubricBucket).Delete(rubricKey(event, name))
+	})
+}
+
+func (bs *BoltStore) ListTracks(event string) ([]Track, error) {
+	tracks := make([]Track, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		prefix := rubricKey(event, "")
+		c := tx.Bucket(tracksBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			t := Track{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			tracks = append(tracks, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// tracks are keyed like the rubric, event + id
+func (bs *BoltStore) SaveTrack(t *Track) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, tracksBucket, rubricKey(t.Event, t.Id), t)
+	})
+}
+
+func (bs *BoltStore) DeleteTrack(event, id string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tracksBucket).Delete(rubricKey(event, id))
+	})
+}
+
+// keys for rows clustered under an abstract are abstract_id + id,
+// like the CQL primary key
+func childKey(absId, id gocql.UUID) []byte {
+	return append(absId.Bytes(), id.Bytes()...)
+}
+
+// calls fn with every row in the bucket that's clustered under absId
+func forEachChild(tx *bolt.Tx, bucket []byte, absId gocql.UUID, fn func(v []byte) error) error {
+	prefix := absId.Bytes()
+	c := tx.Bucket(bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removes every row in the bucket that's clustered under absId
+func deleteChildren(tx *bolt.Tx, bucket []byte, absId gocql.UUID) error {
+	// collect first, deleting under a cursor skips keys
+	prefix := absId.Bytes()
+	keys := make([][]byte, 0)
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	for _, k := range keys {
+		if err := tx.Bucket(bucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// same as the CQL set update: creates the row if it doesn't exist
+func (bs *BoltStore) AddTag(absId gocql.UUID, tag Tag) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		a := Abstract{Id: absId}
+		err := getJSON(tx, abstractsBucket, absId.Bytes(), &a)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		a.Tags = addTag(a.Tags, tag)
+		return putJSON(tx, abstractsBucket, absId.Bytes(), &a)
+	})
+}
+
+func (bs *BoltStore) RemoveTag(absId gocql.UUID, tag Tag) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		a := Abstract{}
+		err := getJSON(tx, abstractsBucket, absId.Bytes(), &a)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		a.Tags = removeTag(a.Tags, tag)
+		return putJSON(tx, abstractsBucket, absId.Bytes(), &a)
+	})
+}
+
+// no index, a scan of the abstracts is cheap in a local file
+func (bs *BoltStore) ListTagged(tag Tag) ([]gocql.UUID, error) {
+	tags, err := bs.ListTags()
+	return tags[tag], err
+}
+
+func (bs *BoltStore) ListTags() (map[Tag][]gocql.UUID, error) {
+	tags := make(map[Tag][]gocql.UUID)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(abstractsBucket).ForEach(func(k, v []byte) error {
+			a := Abstract{}
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			for _, tag := range a.Tags {
+				tags[tag] = append(tags[tag], a.Id)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (bs *BoltStore) SaveScoreAudit(sa *ScoreAudit) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, auditsBucket, childKey(sa.AbsId, sa.Id), sa)
+	})
+}
+
+func (bs *BoltStore) ListScoreAudits(absId gocql.UUID) (ScoreAudits, error) {
+	alist := make(ScoreAudits, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return forEachChild(tx, auditsBucket, absId, func(v []byte) error {
+			sa := ScoreAudit{}
+			if err := json.Unmarshal(v, &sa); err != nil {
+				return err
+			}
+			sa.Created = sa.Id.Time()
+			alist = append(alist, sa)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Created.Before(alist[j].Created)
+	})
+
+	return alist, nil
+}
+
+// assignments are keyed by email + NUL + abstract id, like the CQL
+// primary key, so a reviewer's are found with a prefix seek
+func assignmentKey(email string, absId gocql.UUID) []byte {
+	return append([]byte(email+"\x00"), absId.Bytes()...)
+}
+
+func (bs *BoltStore) listAssignments(prefix []byte) (Assignments, error) {
+	alist := make(Assignments, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(assignedBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			as := Assignment{}
+			if err := json.Unmarshal(v, &as); err != nil {
+				return err
+			}
+			alist = append(alist, as)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Assigned.Before(alist[j].Assigned)
+	})
+
+	return alist, nil
+}
+
+func (bs *BoltStore) ListAssignments() (Assignments, error) {
+	return bs.listAssignments([]byte{})
+}
+
+func (bs *BoltStore) ListReviewerAssignments(email string) (Assignments, error) {
+	return bs.listAssignments([]byte(email + "\x00"))
+}
+
+func (bs *BoltStore) SaveAssignment(as *Assignment) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, assignedBucket, assignmentKey(as.Email, as.AbsId), as)
+	})
+}
+
+func (bs *BoltStore) DeleteAssignment(email string, absId gocql.UUID) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(assignedBucket).Delete(assignmentKey(email, absId))
+	})
+}
+
+// removes an abstract's assignments to every reviewer, they're keyed by
+// email so this is a scan of the bucket
+func deleteAssignments(tx *bolt.Tx, absId gocql.UUID) error {
+	suffix := append([]byte("\x00"), absId.Bytes()...)
+	keys := make([][]byte, 0)
+	err := tx.Bucket(assignedBucket).ForEach(func(k, v []byte) error {
+		if bytes.HasSuffix(k, suffix) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := tx.Bucket(assignedBucket).Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func conflictKey(absId gocql.UUID, email string) []byte {
+	return append(absId.Bytes(), []byte(email)...)
+}
+
+func (bs *BoltStore) ListConflicts() (Conflicts, error) {
+	clist := make(Conflicts, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(conflictsBucket).ForEach(func(k, v []byte) error {
+			c := Conflict{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			clist = append(clist, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return clist, nil
+}
+
+func (bs *BoltStore) FetchConflict(absId gocql.UUID, email string) (c Conflict, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, conflictsBucket, conflictKey(absId, email), &c)
+	})
+	return
+}
+
+func (bs *BoltStore) SaveConflict(c *Conflict) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, conflictsBucket, conflictKey(c.AbsId, c.Email), c)
+	})
+}
+
+func (bs *BoltStore) DeleteConflict(absId gocql.UUID, email string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conflictsBucket).Delete(conflictKey(absId, email))
+	})
+}
+
+func (bs *BoltStore) ListComments(absId gocql.UUID) (Comments, error) {
+	clist := make(Comments, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return forEachChild(tx, commentsBucket, absId, func(v []byte) error {
+			cmt := Comment{}
+			if err := json.Unmarshal(v, &cmt); err != nil {
+				return err
+			}
+			cmt.Created = cmt.Id.Time()
+			clist = append(clist, cmt)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// timeuuid bytes don't sort by time, Cassandra does this for us
+	sort.Slice(clist, func(i, j int) bool {
+		return clist[i].Created.Before(clist[j].Created)
+	})
+
+	return clist, nil
+}
+
+func (bs *BoltStore) FetchComment(absId, id gocql.UUID) (c Comment, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, commentsBucket, childKey(absId, id), &c)
+	})
+	c.Created = c.Id.Time()
+	return
+}
+
+func (bs *BoltStore) SaveComment(c *Comment) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, commentsBucket, childKey(c.AbsId, c.Id), c)
+	})
+}
+
+func (bs *BoltStore) ListAdmins() (Admins, error) {
+	alist := make(Admins, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(adminsBucket).ForEach(func(k, v []byte) error {
+			alist = append(alist, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return alist, nil
+}
+
+func (bs *BoltStore) SaveAdmin(email string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, adminsBucket, []byte(email), map[string]string{"email": email})
+	})
+}
+
+func (bs *BoltStore) DeleteAdmin(email string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(adminsBucket).Delete([]byte(email))
+	})
+}
+
+func (bs *BoltStore) ListReviewers() ([]Reviewer, error) {
+	rlist := make([]Reviewer, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(reviewersBucket).ForEach(func(k, v []byte) error {
+			r := Reviewer{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rlist = append(rlist, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rlist, nil
+}
+
+func (bs *BoltStore) FetchReviewer(email string) (r Reviewer, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, reviewersBucket, []byte(email), &r)
+	})
+	return
+}
+
+func (bs *BoltStore) SaveReviewer(r *Reviewer) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, reviewersBucket, []byte(r.Email), r)
+	})
+}
+
+func (bs *BoltStore) DeleteReviewer(email string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(reviewersBucket).Delete([]byte(email))
+	})
+}
+
+func (bs *BoltStore) ListSpeakers() ([]Speaker, error) {
+	slist := make([]Speaker, 0)
+
+	err := bs.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(speakersBucket).ForEach(func(k, v []byte) error {
+			s := Speaker{}
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			slist = append(slist, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slist, nil
+}
+
+func (bs *BoltStore) FetchSpeaker(email Email) (s Speaker, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, speakersBucket, []byte(email), &s)
+	})
+	return
+}
+
+func (bs *BoltStore) SaveSpeaker(s *Speaker) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, speakersBucket, []byte(s.Email), s)
+	})
+}
+
+func (bs *BoltStore) DeleteSpeaker(email Email) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(speakersBucket).Delete([]byte(email))
+	})
+}
+
+func (bs *BoltStore) LoadSession(id string) (sr SessionRecord, err error) {
+	err = bs.Bolt.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, sessionsBucket, []byte(id), &sr)
+	})
+	return
+}
+
+func (bs *BoltStore) SaveSession(sr *SessionRecord, isNew bool) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		old := SessionRecord{}
+		err := getJSON(tx, sessionsBucket, []byte(sr.Id), &old)
+		if err == nil && !isNew {
+			old.Email = sr.Email
+			old.Modified = sr.Modified
+			return putJSON(tx, sessionsBucket, []byte(sr.Id), &old)
+		} else if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		return putJSON(tx, sessionsBucket, []byte(sr.Id), sr)
+	})
+}
+
+func (bs *BoltStore) DeleteSession(id string) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+func (bs *BoltStore) SaveLoginToken(lt *LoginToken) error {
+	return bs.Bolt.Update(func(tx *bolt.Tx) error {
+		// no TTLs here, drop the stale tokens while we're writing anyways
+		now := time.Now()
+		b := tx.Bucket(tokensBucket)
+		stale := make([][]byte, 0)
+		b.ForEach(func(k, v []byte) error {
+			old := LoginToken{}
+			if json.Unmarshal(v, &old) == nil && now.After(old.Expires) {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return putJSON(tx, tokensBucket, []byte(lt.Id), lt)
+	})
+}
+
+func (bs *BoltStore) ConsumeLoginToken(id string) (lt LoginToken, err error) {
+	err = bs.Bolt.Update(func(tx *bolt.Tx) error {
+		err := getJSON(tx, tokensBucket, []byte(id), &lt)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(tokensBucket).Delete([]byte(id))
+	})
+	return
+}
@@ -0,0 +1,748 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * cassandra.go: the Cassandra implementation of Store, see schema.cql
+ *
+ */
+
+import (
+	"github.com/gocql/gocql"
+	"time"
+)
+
+type CassandraStore struct {
+	Cass *gocql.Session // connected gocql cassandra session
+}
+
+func NewCassandraStore(cass *gocql.Session) *CassandraStore {
+	return &CassandraStore{Cass: cass}
+}
+
+// gocql has its own not found error, translate it to the Store one
+func notFound(err error) error {
+	if err == gocql.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+const abstractColumns = `id, upstream_id, title, body, created, authors,
+       company, jobtitle, bio, tracks, track_ids, tags, version,
+       status, status_by, status_changed, deleted_by, deleted_at,
+       scores_a, scores_b, scores_c, scores_d,
+       scores_e, scores_f, scores_g, scores_names`
+
+// scan destinations in the same order as abstractColumns
+func abstractDest(a *Abstract) []interface{} {
+	return []interface{}{
+		&a.Id, &a.UpstreamId, &a.Title, &a.Body, &a.Created, &a.Authors,
+		&a.Company, &a.JobTitle, &a.Bio, &a.Tracks, &a.TrackIds, &a.Tags, &a.Version,
+		&a.Status, &a.StatusBy, &a.StatusChanged, &a.DeletedBy, &a.DeletedAt,
+		&a.ScoresA, &a.ScoresB, &a.ScoresC, &a.ScoresD,
+		&a.ScoresE, &a.ScoresF, &a.ScoresG, &a.ScoresNames,
+	}
+}
+
+func (cs *CassandraStore) ListAbstracts() (Abstracts, error) {
+	all, err := cs.listAbstracts()
+	return splitTrash(all, false), err
+}
+
+func (cs *CassandraStore) ListTrash() (Abstracts, error) {
+	all, err := cs.listAbstracts()
+	return splitTrash(all, true), err
+}
+
+// there's no index on deleted_at, the trash is found by reading everything
+func (cs *CassandraStore) listAbstracts() (Abstracts, error) {
+	alist := make(Abstracts, 0)
+
+	iq := cs.Cass.Query(`SELECT ` + abstractColumns + ` FROM abstracts`).Iter()
+
+	for {
+		a := Abstract{}
+
+		ok := iq.Scan(abstractDest(&a)...)
+
+		if ok {
+			alist = append(alist, a)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	// the scores table is small enough to read in one go, grouping it
+	// here saves a query per abstract
+	all := make(map[gocql.UUID]CriteriaScores)
+	iq = cs.Cass.Query(`SELECT abstract_id, criterion, email, score FROM scores`).Iter()
+	for {
+		var id gocql.UUID
+		var criterion string
+		var email Email
+		var score Score
+		if !iq.Scan(&id, &criterion, &email, &score) {
+			break
+		}
+		if all[id] == nil {
+			all[id] = make(CriteriaScores)
+		}
+		all[id].set(criterion, email, score)
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	for i := range alist {
+		alist[i].setScores(all[alist[i].Id])
+		alist[i].defaultStatus()
+	}
+
+	return alist, nil
+}
+
+func (cs *CassandraStore) fetchScores(id gocql.UUID) (CriteriaScores, error) {
+	scores := make(CriteriaScores)
+
+	iq := cs.Cass.Query(`SELECT criterion, email, score FROM scores WHERE abstract_id=?`, id).Iter()
+	for {
+		var criterion string
+		var email Email
+		var score Score
+		if !iq.Scan(&criterion, &email, &score) {
+			break
+		}
+		scores.set(criterion, email, score)
+	}
+
+	return scores, iq.Close()
+}
+
+// one page of abstracts in token order using the driver's paging state,
+// scores are fetched per abstract since it's only a page
+func (cs *CassandraStore) PageAbstracts(size int, state []byte) (Abstracts, []byte, error) {
+	alist := make(Abstracts, 0, size)
+
+	// setting the page state turns off automatic paging, so this
+	// only reads one page
+	iq := cs.Cass.Query(`SELECT ` + abstractColumns + ` FROM abstracts`).PageSize(size).PageState(state).Iter()
+	next := iq.PageState()
+	for {
+		a := Abstract{}
+		if !iq.Scan(abstractDest(&a)...) {
+			break
+		}
+		if !a.Deleted() {
+			alist = append(alist, a)
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	for i := range alist {
+		scores, err := cs.fetchScores(alist[i].Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		alist[i].setScores(scores)
+		alist[i].defaultStatus()
+	}
+
+	if len(next) == 0 {
+		next = nil
+	}
+	return alist, next, nil
+}
+
+func (cs *CassandraStore) FetchAbstract(id gocql.UUID) (Abstract, error) {
+	a, err := cs.fetchAbstract(id)
+	return inTrash(a, err, false)
+}
+
+func (cs *CassandraStore) FetchTrash(id gocql.UUID) (Abstract, error) {
+	a, err := cs.fetchAbstract(id)
+	return inTrash(a, err, true)
+}
+
+func (cs *CassandraStore) fetchAbstract(id gocql.UUID) (a Abstract, err error) {
+	q := cs.Cass.Query(`SELECT `+abstractColumns+` FROM abstracts WHERE id=?`, id)
+
+	err = q.Scan(abstractDest(&a)...)
+	if err != nil {
+		return a, notFound(err)
+	}
+
+	scores, err := cs.fetchScores(id)
+	a.setScores(scores)
+	a.defaultStatus()
+
+	return a, err
+}
+
+func (cs *CassandraStore) PurgeAbstract(id gocql.UUID) error {
+	a, err := cs.fetchAbstract(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	for _, tag := range a.Tags {
+		err = cs.Cass.Query(`DELETE FROM abstract_tags WHERE tag=? AND abstract_id=?`, tag, id).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	// assignments are partitioned by reviewer
+	alist, err := cs.ListAssignments()
+	if err != nil {
+		return err
+	}
+	for _, as := range alist {
+		if as.AbsId == id {
+			err = cs.DeleteAssignment(as.Email, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range []string{"scores", "comments", "revisions", "score_audit", "conflicts"} {
+		err := cs.Cass.Query(`DELETE FROM `+table+` WHERE abstract_id=?`, &id).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return cs.Cass.Query(`DELETE FROM abstracts WHERE id=?`, &id).Exec()
+}
+
+// Create a new abstract record in the DB.
+// Scores are written through SaveScore() and status through
+// SaveStatus(), neither is expected to be overwritten by this call.
+func (cs *CassandraStore) SaveAbstract(a *Abstract) error {
+	return cs.Cass.Query(`
+INSERT INTO abstracts (
+       id, upstream_id, title, body, created, authors,
+       company, jobtitle, bio, tracks, track_ids, version
+	)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		&a.Id, &a.UpstreamId, &a.Title,
+		&a.Body, &a.Created, &a.Authors,
+		&a.Company, &a.JobTitle, &a.Bio,
+		&a.Tracks, &a.TrackIds, &a.Version,
+	).Exec()
+}
+
+// a lightweight transaction, rows from before versions existed have
+// a null version which reads as 0
+func (cs *CassandraStore) UpdateAbstract(a *Abstract, version int) error {
+	cond := `IF version = ?`
+	args := []interface{}{
+		a.UpstreamId, a.Title, a.Body, a.Created, a.Authors,
+		a.Company, a.JobTitle, a.Bio, a.Tracks, a.TrackIds, version + 1,
+		a.Id,
+	}
+	if version == 0 {
+		cond = `IF version = null`
+	} else {
+		args = append(args, version)
+	}
+
+	applied, err := cs.Cass.Query(`
+UPDATE abstracts SET
+       upstream_id=?, title=?, body=?, created=?, authors=?,
+       company=?, jobtitle=?, bio=?, tracks=?, track_ids=?, version=?
+WHERE id=? `+cond, args...).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrStale
+	}
+
+	a.Version = version + 1
+	return nil
+}
+
+func (cs *CassandraStore) SaveStatus(a *Abstract) error {
+	query := `UPDATE abstracts SET status=?, status_by=?, status_changed=? WHERE id=?`
+	return cs.Cass.Query(query, a.Status, a.StatusBy, a.StatusChanged, a.Id).Exec()
+}
+
+func (cs *CassandraStore) SaveDeleted(a *Abstract) error {
+	query := `UPDATE abstracts SET deleted_by=?, deleted_at=? WHERE id=?`
+	if a.Deleted() {
+		return cs.Cass.Query(query, a.DeletedBy, a.DeletedAt, a.Id).Exec()
+	}
+	// zero values would be stored as the epoch rather than null
+	return cs.Cass.Query(`DELETE deleted_by, deleted_at FROM abstracts WHERE id=?`, a.Id).Exec()
+}
+
+func (cs *CassandraStore) SaveScore(su *ScoreUpdate) error {
+	query := `INSERT INTO scores (abstract_id, criterion, email, score) VALUES (?, ?, ?, ?)`
+	return cs.Cass.Query(query, su.Id, su.Slot, su.Email, su.Score).Exec()
+}
+
+// the set on the abstract and the index are written separately, the
+// index is what ListTagged and ListTags read
+func (cs *CassandraStore) AddTag(absId gocql.UUID, tag Tag) error {
+	err := cs.Cass.Query(`UPDATE abstracts SET tags = tags + ? WHERE id=?`, []Tag{tag}, absId).Exec()
+	if err != nil {
+		return err
+	}
+	return cs.Cass.Query(`INSERT INTO abstract_tags (tag, abstract_id) VALUES (?, ?)`, tag, absId).Exec()
+}
+
+func (cs *CassandraStore) RemoveTag(absId gocql.UUID, tag Tag) error {
+	err := cs.Cass.Query(`UPDATE abstracts SET tags = tags - ? WHERE id=?`, []Tag{tag}, absId).Exec()
+	if err != nil {
+		return err
+	}
+	return cs.Cass.Query(`DELETE FROM abstract_tags WHERE tag=? AND abstract_id=?`, tag, absId).Exec()
+}
+
+func (cs *CassandraStore) ListTagged(tag Tag) ([]gocql.UUID, error) {
+	ids := make([]gocql.UUID, 0)
+
+	iq := cs.Cass.Query(`SELECT abstract_id FROM abstract_tags WHERE tag=?`, tag).Iter()
+	var id gocql.UUID
+	for iq.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (cs *CassandraStore) ListTags() (map[Tag][]gocql.UUID, error) {
+	tags := make(map[Tag][]gocql.UUID)
+
+	iq := cs.Cass.Query(`SELECT tag, abstract_id FROM abstract_tags`).Iter()
+	var tag Tag
+	var id gocql.UUID
+	for iq.Scan(&tag, &id) {
+		tags[tag] = append(tags[tag], id)
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (cs *CassandraStore) SaveScoreAudit(sa *ScoreAudit) error {
+	query := `INSERT INTO score_audit (abstract_id, id, actor, email, slot, score) VALUES (?, ?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, sa.AbsId, sa.Id, sa.Actor, sa.Email, sa.Slot, sa.Score).Exec()
+}
+
+func (cs *CassandraStore) ListScoreAudits(absId gocql.UUID) (ScoreAudits, error) {
+	alist := make(ScoreAudits, 0)
+
+	query := `SELECT abstract_id, id, actor, email, slot, score FROM score_audit WHERE abstract_id=?`
+	iq := cs.Cass.Query(query, absId).Iter()
+	for {
+		sa := ScoreAudit{}
+		ok := iq.Scan(&sa.AbsId, &sa.Id, &sa.Actor, &sa.Email, &sa.Slot, &sa.Score)
+		if ok {
+			sa.Created = sa.Id.Time()
+			alist = append(alist, sa)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return alist, nil
+}
+
+func (cs *CassandraStore) FetchEvent(name string) (e Event, err error) {
+	query := `SELECT name, blind, revealed FROM events WHERE name=?`
+	err = cs.Cass.Query(query, name).Scan(&e.Name, &e.Blind, &e.Revealed)
+	return e, notFound(err)
+}
+
+func (cs *CassandraStore) SaveEvent(e *Event) error {
+	query := `INSERT INTO events (name, blind, revealed) VALUES (?, ?, ?)`
+	return cs.Cass.Query(query, e.Name, e.Blind, e.Revealed).Exec()
+}
+
+func (cs *CassandraStore) ListRubric(event string) (Rubric, error) {
+	rubric := make(Rubric, 0)
+
+	query := `SELECT event, name, description, min, max, labels, weight, position FROM rubric WHERE event=?`
+	iq := cs.Cass.Query(query, event).Iter()
+	for {
+		c := Criterion{}
+		ok := iq.Scan(&c.Event, &c.Name, &c.Description, &c.Min, &c.Max, &c.Labels, &c.Weight, &c.Position)
+		if ok {
+			rubric = append(rubric, c)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return rubric, nil
+}
+
+func (cs *CassandraStore) SaveCriterion(c *Criterion) error {
+	query := `INSERT INTO rubric (event, name, description, min, max, labels, weight, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, c.Event, c.Name, c.Description, c.Min, c.Max, c.Labels, c.Weight, c.Position).Exec()
+}
+
+func (cs *CassandraStore) DeleteCriterion(event, name string) error {
+	return cs.Cass.Query(`DELETE FROM rubric WHERE event=? AND name=?`, event, name).Exec()
+}
+
+func (cs *CassandraStore) ListTracks(event string) ([]Track, error) {
+	tracks := make([]Track, 0)
+
+	query := `SELECT event, id, name, description, leads, slots FROM tracks WHERE event=?`
+	iq := cs.Cass.Query(query, event).Iter()
+	for {
+		t := Track{}
+		ok := iq.Scan(&t.Event, &t.Id, &t.Name, &t.Description, &t.Leads, &t.Slots)
+		if ok {
+			tracks = append(tracks, t)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+func (cs *CassandraStore) SaveTrack(t *Track) error {
+	query := `INSERT INTO tracks (event, id, name, description, leads, slots) VALUES (?, ?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, t.Event, t.Id, t.Name, t.Description, t.Leads, t.Slots).Exec()
+}
+
+func (cs *CassandraStore) DeleteTrack(event, id string) error {
+	return cs.Cass.Query(`DELETE FROM tracks WHERE event=? AND id=?`, event, id).Exec()
+}
+
+func (cs *CassandraStore) scanAssignments(iq *gocql.Iter) (Assignments, error) {
+	alist := make(Assignments, 0)
+	for {
+		as := Assignment{}
+		ok := iq.Scan(&as.Email, &as.AbsId, &as.Assigned, &as.By)
+		if ok {
+			alist = append(alist, as)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return alist, nil
+}
+
+func (cs *CassandraStore) ListAssignments() (Assignments, error) {
+	iq := cs.Cass.Query(`SELECT email, abstract_id, assigned, assigned_by FROM assignments`).Iter()
+	return cs.scanAssignments(iq)
+}
+
+func (cs *CassandraStore) ListReviewerAssignments(email string) (Assignments, error) {
+	query := `SELECT email, abstract_id, assigned, assigned_by FROM assignments WHERE email=?`
+	return cs.scanAssignments(cs.Cass.Query(query, email).Iter())
+}
+
+func (cs *CassandraStore) SaveAssignment(as *Assignment) error {
+	query := `INSERT INTO assignments (email, abstract_id, assigned, assigned_by) VALUES (?, ?, ?, ?)`
+	return cs.Cass.Query(query, as.Email, as.AbsId, as.Assigned, as.By).Exec()
+}
+
+func (cs *CassandraStore) DeleteAssignment(email string, absId gocql.UUID) error {
+	return cs.Cass.Query(`DELETE FROM assignments WHERE email=? AND abstract_id=?`, email, absId).Exec()
+}
+
+// only manual conflicts are stored
+func (cs *CassandraStore) ListConflicts() (Conflicts, error) {
+	clist := make(Conflicts, 0)
+
+	iq := cs.Cass.Query(`SELECT abstract_id, email, reason, created FROM conflicts`).Iter()
+	for {
+		c := Conflict{Source: ConflictManual}
+		ok := iq.Scan(&c.AbsId, &c.Email, &c.Reason, &c.Created)
+		if ok {
+			clist = append(clist, c)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return clist, nil
+}
+
+func (cs *CassandraStore) FetchConflict(absId gocql.UUID, email string) (c Conflict, err error) {
+	c.Source = ConflictManual
+	query := `SELECT abstract_id, email, reason, created FROM conflicts WHERE abstract_id=? AND email=?`
+	err = cs.Cass.Query(query, absId, email).Scan(&c.AbsId, &c.Email, &c.Reason, &c.Created)
+	return c, notFound(err)
+}
+
+func (cs *CassandraStore) SaveConflict(c *Conflict) error {
+	query := `INSERT INTO conflicts (abstract_id, email, reason, created) VALUES (?, ?, ?, ?)`
+	return cs.Cass.Query(query, c.AbsId, c.Email, c.Reason, c.Created).Exec()
+}
+
+func (cs *CassandraStore) DeleteConflict(absId gocql.UUID, email string) error {
+	return cs.Cass.Query(`DELETE FROM conflicts WHERE abstract_id=? AND email=?`, absId, email).Exec()
+}
+
+const revisionColumns = `abstract_id, id, editor, changed, upstream_id, title, body, authors, company, jobtitle, bio, tracks`
+
+func (cs *CassandraStore) ListRevisions(absId gocql.UUID) (Revisions, error) {
+	revs := make(Revisions, 0)
+
+	iq := cs.Cass.Query(`SELECT `+revisionColumns+` FROM revisions WHERE abstract_id=?`, absId).Iter()
+	for {
+		rv := Revision{}
+		ok := iq.Scan(&rv.AbsId, &rv.Id, &rv.Editor, &rv.Changed, &rv.UpstreamId, &rv.Title,
+			&rv.Body, &rv.Authors, &rv.Company, &rv.JobTitle, &rv.Bio, &rv.Tracks)
+		if ok {
+			rv.Created = rv.Id.Time()
+			revs = append(revs, rv)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+func (cs *CassandraStore) FetchRevision(absId, id gocql.UUID) (rv Revision, err error) {
+	query := `SELECT ` + revisionColumns + ` FROM revisions WHERE abstract_id=? AND id=?`
+	err = cs.Cass.Query(query, absId, id).Scan(&rv.AbsId, &rv.Id, &rv.Editor, &rv.Changed, &rv.UpstreamId,
+		&rv.Title, &rv.Body, &rv.Authors, &rv.Company, &rv.JobTitle, &rv.Bio, &rv.Tracks)
+	rv.Created = rv.Id.Time()
+	return rv, notFound(err)
+}
+
+func (cs *CassandraStore) SaveRevision(rv *Revision) error {
+	query := `INSERT INTO revisions (` + revisionColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, rv.AbsId, rv.Id, rv.Editor, rv.Changed, rv.UpstreamId, rv.Title,
+		rv.Body, rv.Authors, rv.Company, rv.JobTitle, rv.Bio, rv.Tracks).Exec()
+}
+
+func (cs *CassandraStore) ListComments(absId gocql.UUID) (Comments, error) {
+	clist := make(Comments, 0)
+
+	query := `SELECT abstract_id, id, email, body FROM comments WHERE abstract_id=?`
+	iq := cs.Cass.Query(query, absId).Iter()
+	for {
+		c := Comment{}
+		ok := iq.Scan(&c.AbsId, &c.Id, &c.Email, &c.Body)
+		if ok {
+			c.Created = c.Id.Time()
+			clist = append(clist, c)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return clist, nil
+}
+
+func (cs *CassandraStore) FetchComment(absId, id gocql.UUID) (c Comment, err error) {
+	query := `SELECT abstract_id, id, email, body FROM comments WHERE abstract_id=? AND id=?`
+	err = cs.Cass.Query(query, absId, id).Scan(&c.AbsId, &c.Id, &c.Email, &c.Body)
+	c.Created = c.Id.Time()
+	return c, notFound(err)
+}
+
+func (cs *CassandraStore) SaveComment(c *Comment) error {
+	query := `INSERT INTO comments (abstract_id, id, email, body) VALUES (?, ?, ?, ?)`
+	return cs.Cass.Query(query, c.AbsId, c.Id, c.Email, c.Body).Exec()
+}
+
+func (cs *CassandraStore) ListAdmins() (Admins, error) {
+	alist := make(Admins, 0)
+
+	iq := cs.Cass.Query(`SELECT email FROM admins`).Iter()
+
+	for {
+		admin := ""
+		ok := iq.Scan(&admin)
+
+		if ok {
+			alist = append(alist, admin)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return alist, nil
+}
+
+func (cs *CassandraStore) LoadSession(id string) (sr SessionRecord, err error) {
+	query := `SELECT id, email, created, modified FROM sessions WHERE id=?`
+	err = cs.Cass.Query(query, id).Scan(&sr.Id, &sr.Email, &sr.Created, &sr.Modified)
+	return sr, notFound(err)
+}
+
+func (cs *CassandraStore) SaveSession(sr *SessionRecord, isNew bool) error {
+	if isNew {
+		query := `INSERT INTO sessions (id, email, created, modified) VALUES (?, ?, ?, ?)`
+		return cs.Cass.Query(query, sr.Id, sr.Email, sr.Created, sr.Modified).Exec()
+	}
+
+	query := `UPDATE sessions SET email=?, modified=? WHERE id=?`
+	return cs.Cass.Query(query, sr.Email, sr.Modified, sr.Id).Exec()
+}
+
+func (cs *CassandraStore) DeleteSession(id string) error {
+	return cs.Cass.Query(`DELETE FROM sessions WHERE id=?`, id).Exec()
+}
+
+func (cs *CassandraStore) SaveAdmin(email string) error {
+	return cs.Cass.Query(`INSERT INTO admins (email) VALUES (?)`, email).Exec()
+}
+
+func (cs *CassandraStore) DeleteAdmin(email string) error {
+	return cs.Cass.Query(`DELETE FROM admins WHERE email=?`, email).Exec()
+}
+
+func (cs *CassandraStore) ListReviewers() ([]Reviewer, error) {
+	rlist := make([]Reviewer, 0)
+
+	iq := cs.Cass.Query(`SELECT email, added, role, tracks, company FROM reviewers`).Iter()
+	for {
+		r := Reviewer{}
+		ok := iq.Scan(&r.Email, &r.Added, &r.Role, &r.Tracks, &r.Company)
+		if ok {
+			rlist = append(rlist, r)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return rlist, nil
+}
+
+func (cs *CassandraStore) FetchReviewer(email string) (r Reviewer, err error) {
+	query := `SELECT email, added, role, tracks, company FROM reviewers WHERE email=?`
+	err = cs.Cass.Query(query, email).Scan(&r.Email, &r.Added, &r.Role, &r.Tracks, &r.Company)
+	return r, notFound(err)
+}
+
+func (cs *CassandraStore) SaveReviewer(r *Reviewer) error {
+	query := `INSERT INTO reviewers (email, added, role, tracks, company) VALUES (?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, r.Email, r.Added, r.Role, r.Tracks, r.Company).Exec()
+}
+
+func (cs *CassandraStore) DeleteReviewer(email string) error {
+	return cs.Cass.Query(`DELETE FROM reviewers WHERE email=?`, email).Exec()
+}
+
+const speakerColumns = `email, name, bio, company, jobtitle, photo, social, updated`
+
+func speakerDest(s *Speaker) []interface{} {
+	return []interface{}{&s.Email, &s.Name, &s.Bio, &s.Company, &s.JobTitle, &s.Photo, &s.Social, &s.Updated}
+}
+
+func (cs *CassandraStore) ListSpeakers() ([]Speaker, error) {
+	slist := make([]Speaker, 0)
+
+	iq := cs.Cass.Query(`SELECT ` + speakerColumns + ` FROM speakers`).Iter()
+	for {
+		s := Speaker{}
+		ok := iq.Scan(speakerDest(&s)...)
+		if ok {
+			slist = append(slist, s)
+		} else {
+			break
+		}
+	}
+	if err := iq.Close(); err != nil {
+		return nil, err
+	}
+
+	return slist, nil
+}
+
+func (cs *CassandraStore) FetchSpeaker(email Email) (s Speaker, err error) {
+	query := `SELECT ` + speakerColumns + ` FROM speakers WHERE email=?`
+	err = cs.Cass.Query(query, email).Scan(speakerDest(&s)...)
+	return s, notFound(err)
+}
+
+func (cs *CassandraStore) SaveSpeaker(s *Speaker) error {
+	query := `INSERT INTO speakers (` + speakerColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	return cs.Cass.Query(query, s.Email, s.Name, s.Bio, s.Company, s.JobTitle, s.Photo, s.Social, s.Updated).Exec()
+}
+
+func (cs *CassandraStore) DeleteSpeaker(email Email) error {
+	return cs.Cass.Query(`DELETE FROM speakers WHERE email=?`, email).Exec()
+}
+
+// tokens are written with a TTL so Cassandra cleans up the unused ones
+func (cs *CassandraStore) SaveLoginToken(lt *LoginToken) error {
+	ttl := int(time.Until(lt.Expires).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+	query := `INSERT INTO login_tokens (id, email, expires) VALUES (?, ?, ?) USING TTL ?`
+	return cs.Cass.Query(query, lt.Id, lt.Email, lt.Expires, ttl).Exec()
+}
+
+// the LWT delete makes sure only one request gets to use a token
+func (cs *CassandraStore) ConsumeLoginToken(id string) (lt LoginToken, err error) {
+	query := `SELECT id, email, expires FROM login_tokens WHERE id=?`
+	err = cs.Cass.Query(query, id).Scan(&lt.Id, &lt.Email, &lt.Expires)
+	if err != nil {
+		return lt, notFound(err)
+	}
+
+	applied, err := cs.Cass.Query(`DELETE FROM login_tokens WHERE id=? IF EXISTS`, id).ScanCAS()
+	if err != nil {
+		return lt, err
+	}
+	if !applied {
+		return lt, ErrNotFound
+	}
+
+	return lt, nil
+}
@@ -15,7 +15,7 @@ package main
  * See the License for the specific language governing permissions and
  * limitations under the License.
  *
- * comments.go: Functions for managing comments.
+ * comments.go: types for reviewer comments on abstracts.
  *
  */
 
@@ -33,30 +33,3 @@ type Comment struct {
 }
 
 type Comments []Comment
-
-func ListComments(cass *gocql.Session, absId gocql.UUID) (Comments, error) {
-	clist := make(Comments, 0)
-
-	query := `SELECT abstract_id, id, email, body FROM comments WHERE abstract_id=?`
-	iq := cass.Query(query, absId).Iter()
-	for {
-		c := Comment{}
-		ok := iq.Scan(&c.AbsId, &c.Id, &c.Email, &c.Body)
-		if ok {
-			c.Created = c.Id.Time()
-			clist = append(clist, c)
-		} else {
-			break
-		}
-	}
-	if err := iq.Close(); err != nil {
-		return nil, err
-	}
-
-	return clist, nil
-}
-
-func (c *Comment) Save(cass *gocql.Session) error {
-	query := `INSERT INTO comments (abstract_id, id, email, body) VALUES (?, ?, ?, ?)`
-	return cass.Query(query, c.AbsId, c.Id, c.Email, c.Body).Exec()
-}
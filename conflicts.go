@@ -0,0 +1,366 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * conflicts.go: conflicts of interest and recusal
+ *
+ * A reviewer with a conflict on an abstract can't score it, can't see
+ * its scores or comments, and any scores they already gave are left out
+ * of the ranking and agreement numbers. Conflicts come from two places:
+ *
+ *   auto    the reviewer is an author, works at the abstract's company,
+ *           or shares an email domain with an author. These are worked
+ *           out on every check so they follow edits to the abstract.
+ *   manual  declared by the reviewer or an admin, in the conflicts table
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ConflictAuto   = "auto"
+	ConflictManual = "manual"
+)
+
+type Conflict struct {
+	AbsId   gocql.UUID `json:"abstract_id"`
+	Email   string     `json:"email"`
+	Source  string     `json:"source"` // auto or manual
+	Reason  string     `json:"reason"`
+	Created time.Time  `json:"created"`
+}
+
+type Conflicts []Conflict
+
+// sharing one of these with an author doesn't mean anything
+var freemailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"yahoo.com":      true,
+	"hotmail.com":    true,
+	"outlook.com":    true,
+	"live.com":       true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"aol.com":        true,
+	"protonmail.com": true,
+	"gmx.com":        true,
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// autoConflict checks a reviewer against an abstract, company is the
+// reviewer's from the reviewers table and may be empty
+func autoConflict(email, company string, a *Abstract) (string, bool) {
+	domain := emailDomain(email)
+	for author := range a.Authors {
+		if strings.EqualFold(string(author), email) {
+			return "author", true
+		}
+		if domain != "" && !freemailDomains[domain] && emailDomain(string(author)) == domain {
+			return "same email domain as an author", true
+		}
+	}
+
+	company = strings.TrimSpace(company)
+	if company != "" && strings.EqualFold(company, strings.TrimSpace(a.Company)) {
+		return "same company", true
+	}
+
+	return "", false
+}
+
+// conflictIndex answers conflict checks for many reviewers and abstracts
+// without going back to the store for each one
+type conflictIndex struct {
+	manual    map[gocql.UUID]map[string]Conflict
+	companies map[string]string // reviewer email -> company
+}
+
+func loadConflictIndex() (*conflictIndex, error) {
+	ci := &conflictIndex{
+		manual:    make(map[gocql.UUID]map[string]Conflict),
+		companies: make(map[string]string),
+	}
+
+	clist, err := db.ListConflicts()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clist {
+		if ci.manual[c.AbsId] == nil {
+			ci.manual[c.AbsId] = make(map[string]Conflict)
+		}
+		// rows saved before emails were lowercased
+		c.Email = normalizeEmail(c.Email)
+		ci.manual[c.AbsId][c.Email] = c
+	}
+
+	rlist, err := db.ListReviewers()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rlist {
+		ci.companies[normalizeEmail(r.Email)] = r.Company
+	}
+
+	return ci, nil
+}
+
+func (ci *conflictIndex) check(email string, a *Abstract) (Conflict, bool) {
+	email = normalizeEmail(email)
+	if c, ok := ci.manual[a.Id][email]; ok {
+		return c, true
+	}
+	if reason, ok := autoConflict(email, ci.companies[email], a); ok {
+		return Conflict{AbsId: a.Id, Email: email, Source: ConflictAuto, Reason: reason}, true
+	}
+	return Conflict{}, false
+}
+
+// checkConflict is for checking a single reviewer and abstract
+func checkConflict(email string, a *Abstract) (Conflict, bool, error) {
+	c, err := db.FetchConflict(a.Id, normalizeEmail(email))
+	if err == nil {
+		return c, true, nil
+	} else if err != ErrNotFound {
+		return c, false, err
+	}
+
+	company := ""
+	rev, err := fetchReviewer(email)
+	if err == nil {
+		company = rev.Company
+	} else if err != ErrNotFound {
+		return c, false, err
+	}
+
+	if reason, ok := autoConflict(email, company, a); ok {
+		return Conflict{AbsId: a.Id, Email: email, Source: ConflictAuto, Reason: reason}, true, nil
+	}
+
+	return Conflict{}, false, nil
+}
+
+// recused writes an error and returns true if the abstract doesn't
+// exist or email has a conflict with it
+func recused(w http.ResponseWriter, email string, id gocql.UUID) bool {
+	a, err := db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return true
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch abstract: %s", err), 500)
+		return true
+	}
+
+	_, ok, err := checkConflict(email, &a)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to check conflicts: %s", err), 500)
+		return true
+	}
+	// the reason isn't included, it could give away the speaker during blind review
+	if ok {
+		http.Error(w, fmt.Sprintf("'%s' has a conflict of interest with this abstract", email), http.StatusForbidden)
+		return true
+	}
+
+	return false
+}
+
+// hideScores is what a conflicted reviewer gets to see of the scores
+func (a *Abstract) hideScores() {
+	a.Scores = nil
+	for _, slot := range legacySlots {
+		*a.slotScores(slot) = nil
+	}
+}
+
+// dropConflicted returns copies of the abstracts without scores from
+// conflicted reviewers, for aggregates and for non-admins, whose clients
+// total up the legacy slots themselves
+func dropConflicted(alist Abstracts, ci *conflictIndex) Abstracts {
+	out := make(Abstracts, len(alist))
+	for i, a := range alist {
+		out[i] = a
+		out[i].Scores = make(CriteriaScores)
+		for criterion, scores := range a.Scores {
+			for email, score := range scores {
+				if _, ok := ci.check(string(email), &a); !ok {
+					out[i].Scores.set(criterion, email, score)
+				}
+			}
+		}
+		for _, slot := range legacySlots {
+			*out[i].slotScores(slot) = out[i].Scores[slot].copy()
+		}
+	}
+	return out
+}
+
+// GET lists conflicts, all of them for admins, otherwise only the
+// logged-in user's. Auto conflicts are included for everybody in the
+// reviewers table.
+func ConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ConflictsHandler failed: %s", err), 500)
+		return
+	}
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ConflictsHandler failed to list abstracts: %s", err), 500)
+		return
+	}
+
+	emails := []string{u.Email}
+	if u.Can(PermAdmin) {
+		emails = make([]string, 0, len(ci.companies))
+		for email := range ci.companies {
+			emails = append(emails, email)
+		}
+		// manual conflicts for people not in the reviewers table, e.g. admins
+		for _, byEmail := range ci.manual {
+			for email := range byEmail {
+				if _, ok := ci.companies[email]; !ok {
+					ci.companies[email] = ""
+					emails = append(emails, email)
+				}
+			}
+		}
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ConflictsHandler failed to load event settings: %s", err), 500)
+		return
+	}
+	blind := ev.hidesIdentity(u)
+
+	clist := make(Conflicts, 0)
+	for i := range alist {
+		for _, email := range emails {
+			if c, ok := ci.check(email, &alist[i]); ok {
+				// "same company" etc. would say who the speaker is
+				if blind && c.Source == ConflictAuto {
+					c.Reason = ""
+				}
+				clist = append(clist, c)
+			}
+		}
+	}
+
+	jsonOut(w, r, clist)
+}
+
+// PUT declares a conflict, reviewers can declare their own and admins
+// can declare anybody's. Only admins can DELETE, and only manual ones.
+func ConflictHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := gocql.ParseUUID(vars["abstract_id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+	email, err := parseEmail(vars["email"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid email address: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		if email != u.Email && !u.Can(PermAdmin) {
+			http.Error(w, "only admins can declare conflicts for somebody else", http.StatusForbidden)
+			return
+		}
+
+		_, err = db.FetchAbstract(id)
+		if err == ErrNotFound {
+			http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("ConflictHandler/PUT failed: %s", err), 500)
+			return
+		}
+
+		c := Conflict{}
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(r.Body).Decode(&c)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("ConflictHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		c.AbsId = id
+		c.Email = email
+		c.Source = ConflictManual
+		c.Created = time.Now()
+
+		err = db.SaveConflict(&c)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ConflictHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("ConflictHandler: PUT '%s' on %s by '%s'\n", email, id, u.Email)
+		jsonOut(w, r, c)
+	case "DELETE":
+		if !u.Can(PermAdmin) {
+			http.Error(w, "'admin' permission required", http.StatusForbidden)
+			return
+		}
+
+		err = db.DeleteConflict(id, email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ConflictHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("ConflictHandler: DELETE '%s' on %s by '%s'\n", email, id, u.Email)
+		jsonOut(w, r, map[string]string{"abstract_id": id.String(), "email": email})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * conflicts_test.go: conflict checks and declarations
+ *
+ */
+
+import (
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestConflictEmailCase(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "bob@example.com"})
+	declared, legacy := gocql.TimeUUID(), gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: declared, Title: "declared"})
+	ms.SaveAbstract(&Abstract{Id: legacy, Title: "legacy"})
+	// saved before emails were lowercased
+	ms.SaveConflict(&Conflict{AbsId: legacy, Email: "BOB@example.com", Source: ConflictManual})
+
+	h := newRouter()
+	if rec := do(t, h, loginAs(t, "adm@x"), "PUT", "/conflicts/"+declared.String()+"/Bob@Example.com", ""); rec.Code != 200 {
+		t.Fatalf("PUT conflict = %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := ms.FetchConflict(declared, "bob@example.com"); err != nil {
+		t.Errorf("declared conflict wasn't stored lowercased: %v", err)
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []gocql.UUID{declared, legacy} {
+		a, _ := ms.FetchAbstract(id)
+		if _, ok := ci.check("bob@example.com", &a); !ok {
+			t.Errorf("%s: the index missed the conflict", a.Title)
+		}
+	}
+
+	a, _ := ms.FetchAbstract(declared)
+	if _, ok, err := checkConflict("BOB@example.com", &a); !ok || err != nil {
+		t.Errorf("checkConflict with different case = %v %v", ok, err)
+	}
+	rec := do(t, h, loginAs(t, "bob@example.com"), "POST", "/updatescores", `[{"id":"`+declared.String()+`","slot":"scores_a","score":2}]`)
+	if rec.Code != 403 {
+		t.Errorf("conflicted reviewer scoring = %d, want 403", rec.Code)
+	}
+}
@@ -17,12 +17,12 @@ package main
  *
  * cqlstore.go: a quick & dirty Cassandra CQL backend for Gorilla sessions
  *
- * This implementation is specific to this application.
+ * This implementation is specific to this application. Despite the name
+ * the session records are kept in whatever Store the app was started with.
  *
  */
 
 import (
-	"errors"
 	"github.com/gocql/gocql"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
@@ -32,14 +32,14 @@ import (
 )
 
 type CQLStore struct {
-	Cass    *gocql.Session       // connected gocql cassandra session
+	Data    Store                // where session records are kept
 	Codecs  []securecookie.Codec // session codecs
 	Options *sessions.Options    // default configuration
 }
 
-func NewCQLStore(cass *gocql.Session, keyPairs ...[]byte) *CQLStore {
+func NewCQLStore(data Store, keyPairs ...[]byte) *CQLStore {
 	return &CQLStore{
-		Cass:   cass,
+		Data:   data,
 		Codecs: securecookie.CodecsFromPairs(keyPairs...),
 		Options: &sessions.Options{
 			Path:   "/",
@@ -96,7 +96,7 @@ func (cs *CQLStore) New(r *http.Request, name string) (sess *sessions.Session, e
 func (cs *CQLStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) (err error) {
 	err = cs.save(sess)
 	if err != nil {
-		log.Printf("cqlstore: Failed to save session to Cassandra: %s\n", err)
+		log.Printf("cqlstore: Failed to save session: %s\n", err)
 		return err
 	}
 
@@ -117,41 +117,57 @@ func (cs *CQLStore) Delete(r *http.Request, w http.ResponseWriter, sess *session
 	http.SetCookie(w, sessions.NewCookie(sess.Name(), "", &opts))
 
 	// delete the session from the DB
-	err = cs.Cass.Query(`DELETE FROM sessions WHERE id=?`, sess.ID).Exec()
+	err = cs.Data.DeleteSession(sess.ID)
 	return
 }
 
-// load session data from Cassandra
+// Renew deletes the session's record and gives it a new ID, the values
+// are kept. Call it when the session gains privileges so an ID somebody
+// planted before login is worthless after it.
+func (cs *CQLStore) Renew(sess *sessions.Session) (err error) {
+	if !sess.IsNew {
+		err = cs.Data.DeleteSession(sess.ID)
+		if err != nil {
+			log.Printf("cqlstore: failed to delete session ID '%s': %s\n", sess.ID, err)
+			return err
+		}
+	}
+
+	uuid, err := gocql.RandomUUID()
+	if err != nil {
+		log.Printf("cqlstore: failed to generate a new UUID: %s\n", err)
+		return err
+	}
+	sess.ID = uuid.String()
+	sess.IsNew = true
+
+	return nil
+}
+
+// load session data from the Store
 func (cs *CQLStore) load(sess *sessions.Session) (err error) {
-	var email string
-	var created, modified time.Time
-	query := `SELECT email, created, modified FROM sessions WHERE id=?`
-	iq := cs.Cass.Query(query, sess.ID).Iter()
-	ok := iq.Scan(&email, &created, &modified)
-	if ok {
-		// expose the created/modified times through the session values
-		sess.Values["created"] = created
-		sess.Values["modified"] = modified
-		sess.Values["email"] = email
-		sess.IsNew = false
-		return
-	} else {
-		log.Printf("cqlstore: CQL query for session ID '%s' failed.\n", sess.ID)
-		return errors.New("CQL query failed.")
+	sr, err := cs.Data.LoadSession(sess.ID)
+	if err != nil {
+		log.Printf("cqlstore: loading session ID '%s' failed: %s\n", sess.ID, err)
+		return err
 	}
+
+	// expose the created/modified times through the session values
+	sess.Values["created"] = sr.Created
+	sess.Values["modified"] = sr.Modified
+	sess.Values["email"] = sr.Email
+	sess.IsNew = false
 	return
 }
 
 func (cs *CQLStore) save(sess *sessions.Session) (err error) {
 	now := time.Now()
-	email := sess.Values["email"].(string)
-
-	if sess.IsNew {
-		query := `INSERT INTO sessions (id, email, created, modified) VALUES (?, ?, ?, ?)`
-		err = cs.Cass.Query(query, sess.ID, email, now, now).Exec()
-	} else {
-		query := `UPDATE sessions SET email=?, modified=? WHERE id=?`
-		err = cs.Cass.Query(query, email, now, sess.ID).Exec()
+	sr := SessionRecord{
+		Id:       sess.ID,
+		Email:    sess.Values["email"].(string),
+		Created:  now,
+		Modified: now,
 	}
-	return
+
+	return cs.Data.SaveSession(&sr, sess.IsNew)
 }
@@ -27,12 +27,14 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
-var fileFlag, cqlFlag string
+var fileFlag, cqlFlag, eventFlag string
 var writeJsonFlag bool
 
 // 2016 CSV export fields
@@ -71,6 +73,7 @@ var fields []string = []string{
 func init() {
 	flag.StringVar(&fileFlag, "file", "", "input filename to read")
 	flag.StringVar(&cqlFlag, "cql", "", "writing to Cassandra instance at addr:port")
+	flag.StringVar(&eventFlag, "event", "cfp", "event the tracks are created under")
 	flag.BoolVar(&writeJsonFlag, "json", false, "dump JSON to stdout")
 }
 
@@ -96,6 +99,19 @@ func main() {
 	}
 
 	abstracts := make(Abstracts, 0)
+	tracks := make(map[string]string) // id -> name
+	speakers := make([]Speaker, 0)
+	seenSpeakers := make(map[Email]bool)
+
+	// the first row a speaker shows up in is the one their profile comes from
+	addSpeaker := func(s Speaker) {
+		s.Email = Email(strings.ToLower(strings.TrimSpace(string(s.Email))))
+		if s.Email == "" || seenSpeakers[s.Email] {
+			return
+		}
+		seenSpeakers[s.Email] = true
+		speakers = append(speakers, s)
+	}
 
 	for {
 		rec, err := rdr.Read()
@@ -115,7 +131,16 @@ func main() {
 			Email(rec[f["Email"]]): name,
 		}
 		bio := rec[f["Quick Biography"]]
+		addSpeaker(Speaker{
+			Email:    Email(rec[f["Email"]]),
+			Name:     name,
+			Bio:      bio,
+			Company:  rec[f["Company Name"]],
+			JobTitle: rec[f["Job Title"]],
+		})
 
+		// the co-presenter gets their own speaker profile rather than
+		// having their bio tacked onto the abstract's
 		copresenter := strings.ToLower(rec[f["Will there be another presenter?"]])
 		if strings.HasPrefix(copresenter, "y") {
 			cpname := fmt.Sprintf("%s %s", rec[f["Co-Presenter First Name"]], rec[f["Co-Presenter Last Name"]])
@@ -123,24 +148,55 @@ func main() {
 
 			authors[cpemail] = cpname
 
-			bio = bio + "\n\n" + cpname + ": " + rec[f["Co-Presenter Quick Biography"]]
+			addSpeaker(Speaker{
+				Email:    cpemail,
+				Name:     cpname,
+				Bio:      rec[f["Co-Presenter Quick Biography"]],
+				Company:  rec[f["Co-Presenter Company Name"]],
+				JobTitle: rec[f["Co-Presenter Job Title"]],
+			})
 		}
 
-		// collapse the track
+		// collapse the track, each one also becomes a row in the tracks table
 		track := rec[f["Tracks"]]
+		names := []string{track}
 		firstTrackIdx := f["Tracks"] + 1
 		lastTrackIdx := f["If Other, please specify"]
 		for _, subtrack := range rec[firstTrackIdx : lastTrackIdx+1] {
 			if strings.Trim(subtrack, " ,.") != "" {
 				track = track + ", " + subtrack
+				names = append(names, subtrack)
 			}
 		}
+		// a sub-track can repeat the main track, ids are sorted and
+		// unique like the app keeps them
+		trackIds := make([]string, 0, len(names))
+		seen := make(map[string]bool)
+		for _, name := range names {
+			id := trackSlug(name)
+			if id != "" && !seen[id] {
+				seen[id] = true
+				trackIds = append(trackIds, id)
+				if _, ok := tracks[id]; !ok {
+					tracks[id] = strings.TrimSpace(name)
+				}
+			}
+		}
+		sort.Strings(trackIds)
+
+		// and the names follow the ids, also like the app
+		trackNames := make([]string, len(trackIds))
+		for i, id := range trackIds {
+			trackNames[i] = tracks[id]
+		}
+		if len(trackNames) > 0 {
+			track = strings.Join(trackNames, ", ")
+		}
 
 		subidtxt := rec[f["SubmissionID"]]
 		subid, err := strconv.Atoi(subidtxt)
 		if err != nil {
-			fmt.Sprintf("Could not convert %q to int: %s", subidtxt, err)
-			panic(err)
+			log.Fatalf("Could not convert %q to int: %s", subidtxt, err)
 		}
 
 		a := Abstract{
@@ -154,6 +210,8 @@ func main() {
 			JobTitle:   rec[f["Job Title"]],
 			Company:    rec[f["Company Name"]],
 			Tracks:     track,
+			TrackIds:   trackIds,
+			Version:    1,
 		}
 
 		abstracts = append(abstracts, a)
@@ -170,15 +228,35 @@ func main() {
 		}
 		defer cass.Close()
 
+		// tracks that already exist keep their description, leads and slots
+		for id, name := range tracks {
+			err = cass.Query(`INSERT INTO tracks (event, id, name) VALUES (?, ?, ?) IF NOT EXISTS`, eventFlag, id, name).Exec()
+			if err != nil {
+				log.Printf("Failed to save track '%s': %s\n", id, err)
+			}
+		}
+
+		// profiles edited in the app aren't overwritten by a re-import
+		for _, s := range speakers {
+			err = cass.Query(`INSERT INTO speakers (email, name, bio, company, jobtitle, updated) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`,
+				s.Email, s.Name, s.Bio, s.Company, s.JobTitle, time.Now()).Exec()
+			if err != nil {
+				log.Printf("Failed to save speaker '%s': %s\n", s.Email, err)
+			}
+		}
+
 		for _, a := range abstracts {
-			err = a.Save(cass)
+			err = saveAbstract(cass, &a)
 			if err != nil {
 				log.Printf("Failed to save record: %s\n", err)
 			}
 		}
 	} else {
 		for _, a := range abstracts {
-			fmt.Printf("%+q\n\n", a)
+			fmt.Printf("%+v\n\n", a)
+		}
+		for _, s := range speakers {
+			fmt.Printf("%+v\n\n", s)
 		}
 	}
 
@@ -190,3 +268,41 @@ func main() {
 		os.Stdout.Write(js)
 	}
 }
+
+// an abstract that's already there keeps its edits and version, the
+// app's conditional updates count on the version only going up
+func saveAbstract(cass *gocql.Session, a *Abstract) error {
+	return cass.Query(`
+INSERT INTO abstracts (
+       id, upstream_id, title, body, created, authors,
+       company, jobtitle, bio, tracks, track_ids, version
+	)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`,
+		&a.Id, &a.UpstreamId, &a.Title,
+		&a.Body, &a.Created, &a.Authors,
+		&a.Company, &a.JobTitle, &a.Bio,
+		&a.Tracks, &a.TrackIds, &a.Version,
+	).Exec()
+}
+
+// the columns of the app's Speaker that come from the CSV
+type Speaker struct {
+	Email    Email
+	Name     string
+	Bio      string
+	Company  string
+	JobTitle string
+}
+
+// same as trackSlug in the app's tracks.go
+func trackSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slug := strings.Join(words, "-")
+	if len(slug) > 64 {
+		slug = strings.TrimRight(slug[:64], "-")
+	}
+	return slug
+}
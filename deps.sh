@@ -8,3 +8,4 @@ go get -u github.com/gorilla/securecookie
 go get -u github.com/gorilla/sessions
 go get -u github.com/gorilla/mux
 
+go get -u go.etcd.io/bbolt
@@ -0,0 +1,112 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * events.go: per-event settings, selected with -event
+ *
+ * Blind review hides who submitted an abstract from everybody but
+ * admins. Setting revealed starts the second phase, where everybody
+ * can see the speakers again without losing track of the fact that the
+ * first round was blind.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type Event struct {
+	Name     string `json:"name"`
+	Blind    bool   `json:"blind"`    // hide speaker identity from non-admins
+	Revealed bool   `json:"revealed"` // blind review is over, show everything
+}
+
+// fetchEvent returns the settings for the current event, events that
+// haven't been set up get the defaults
+func fetchEvent() (Event, error) {
+	e, err := db.FetchEvent(eventFlag)
+	if err == ErrNotFound {
+		return Event{Name: eventFlag}, nil
+	}
+	return e, err
+}
+
+// hidesIdentity is true if u shouldn't see who wrote the abstracts
+func (e *Event) hidesIdentity(u *User) bool {
+	return e.Blind && !e.Revealed && !u.Can(PermAdmin)
+}
+
+// hideIdentity strips everything that says who the speaker is
+func (a *Abstract) hideIdentity() {
+	a.Authors = Authors{}
+	a.Company = ""
+	a.JobTitle = ""
+	a.Bio = ""
+}
+
+// keepIdentity copies the identity fields from the stored abstract, for
+// edits by people who were never shown them
+func (a *Abstract) keepIdentity(old *Abstract) {
+	a.Authors = old.Authors
+	a.Company = old.Company
+	a.JobTitle = old.JobTitle
+	a.Bio = old.Bio
+}
+
+// GET returns the event settings so the UI can tell what mode it's in,
+// PUT changes them and is admin-only
+func EventHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		e, err := fetchEvent()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("EventHandler/GET failed: %s", err), 500)
+			return
+		}
+		jsonOut(w, r, e)
+	case "PUT":
+		if !u.Can(PermAdmin) {
+			http.Error(w, "'admin' permission required", http.StatusForbidden)
+			return
+		}
+
+		e := Event{}
+		err := json.NewDecoder(r.Body).Decode(&e)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("EventHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+			return
+		}
+		e.Name = eventFlag
+
+		err = db.SaveEvent(&e)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("EventHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("EventHandler: '%s' set %s blind=%t revealed=%t\n", u.Email, e.Name, e.Blind, e.Revealed)
+		jsonOut(w, r, e)
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
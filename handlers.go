@@ -32,27 +32,35 @@ import (
 func RootHandler(w http.ResponseWriter, r *http.Request) {
 	// check for auth but ignore the result: this will initialize
 	// the cookie on page load
-	checkAuth(w, r, false)
+	sessionUser(w, r)
 	http.ServeFile(w, r, "./public/index.html")
 }
 
 func AbstractsHandler(w http.ResponseWriter, r *http.Request) {
-	if !checkAuth(w, r, false) {
+	// partial updates live in patch.go
+	if r.Method == "PATCH" {
+		PatchAbstractHandler(w, r)
+		return
+	}
+
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load event settings: %s", err), 500)
 		return
 	}
 
 	a := Abstract{}
 	dec := json.NewDecoder(r.Body)
-	err := dec.Decode(&a)
+	err = dec.Decode(&a)
 
 	switch r.Method {
 	case "GET":
-		alist, err := ListAbstracts(cass)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to list abstracts: %s", err), 500)
-			return
-		}
-		jsonOut(w, r, alist)
+		listAbstracts(w, r, u, &ev)
 		return
 	case "PUT":
 		if err != nil {
@@ -63,19 +71,26 @@ func AbstractsHandler(w http.ResponseWriter, r *http.Request) {
 
 		a.Id = gocql.TimeUUID()
 		a.Created = time.Now()
-	case "PATCH":
-		if err != nil {
-			log.Printf("AbstractsHandler/PATCH invalid json data: %s", err)
-			http.Error(w, fmt.Sprintf("AbstractsHandler/PATCH invalid json data: %s", err), 500)
-			return
-		}
 	default:
 		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), 500)
 		return
 	}
 
-	// GET returns early, anything else requires admin privs
-	if !checkAuth(w, r, true) {
+	// GET returns early, anything else requires edit privs
+	if !u.Can(PermEdit) {
+		http.Error(w, "'edit' permission required", http.StatusForbidden)
+		return
+	}
+
+	err = resolveTracks(&a, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// track leads can only add abstracts to their own tracks
+	if !u.CanEdit(&a) {
+		http.Error(w, "abstract is not in your tracks", http.StatusForbidden)
 		return
 	}
 
@@ -86,18 +101,48 @@ func AbstractsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.Save(cass)
+	// tags are saved separately, see tags.go
+	tags := make([]Tag, 0, len(a.Tags))
+	for _, t := range a.Tags {
+		tag, err := parseTag(string(t))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tags = addTag(tags, tag)
+	}
+	a.Tags = nil
+
+	err = saveAbstract(&a, nil, u.Email)
+	if err != nil {
+		log.Printf("AbstractsHandler/%s saveAbstract() failed: %s", r.Method, err)
+		http.Error(w, fmt.Sprintf("AbstractsHandler/%s saveAbstract() failed: %s", r.Method, err), 500)
+		return
+	}
+
+	for _, tag := range tags {
+		err = db.AddTag(a.Id, tag)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AbstractsHandler/%s AddTag() failed: %s", r.Method, err), 500)
+			return
+		}
+	}
+	a.Tags = tags
+
+	err = ensureSpeakers(&a)
 	if err != nil {
-		log.Printf("AbstractsHandler/%s a.Save() failed: %s", r.Method, err)
-		http.Error(w, fmt.Sprintf("AbstractsHandler/%s a.Save() failed: %s", r.Method, err), 500)
+		http.Error(w, fmt.Sprintf("AbstractsHandler/%s ensureSpeakers() failed: %s", r.Method, err), 500)
 		return
 	}
 
+	setETag(w, a.Version)
 	jsonOut(w, r, a)
 }
 
+// conflicted reviewers get the abstract without any scores
 func GetAbstractHandler(w http.ResponseWriter, r *http.Request) {
-	if !checkAuth(w, r, false) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
 		return
 	}
 
@@ -107,12 +152,52 @@ func GetAbstractHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 500)
 		return
 	}
-	a, _ := FetchAbstract(cass, id)
-	jsonOut(w, r, a)
+	a, err := db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch abstract: %s", err), 500)
+		return
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to check conflicts: %s", err), 500)
+		return
+	}
+	// same as the list, see lister.item
+	if !u.Can(PermAdmin) {
+		a = dropConflicted(Abstracts{a}, ci)[0]
+	}
+	if _, conflicted := ci.check(u.Email, &a); conflicted {
+		a.hideScores()
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load event settings: %s", err), 500)
+		return
+	}
+	blind := ev.hidesIdentity(u)
+	if blind {
+		a.hideIdentity()
+	}
+
+	// speaker profiles and their other submissions, see speakers.go
+	d, err := abstractDetail(&a, blind)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch speakers: %s", err), 500)
+		return
+	}
+
+	setETag(w, a.Version)
+	jsonOut(w, r, d)
 }
 
 func DeleteAbstractHandler(w http.ResponseWriter, r *http.Request) {
-	if !checkAuth(w, r, true) {
+	u := authorize(w, r, PermEdit)
+	if u == nil {
 		return
 	}
 
@@ -123,14 +208,43 @@ func DeleteAbstractHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = DeleteAbstract(cass, id)
+	a, err := db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !u.CanEdit(&a) {
+		http.Error(w, "abstract is not in your tracks", http.StatusForbidden)
+		return
+	}
+
+	// this only moves it to the trash, see trash.go
+	a.DeletedBy = u.Email
+	a.DeletedAt = time.Now()
+	err = db.SaveDeleted(&a)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	search.remove(id)
+	log.Printf("DeleteAbstractHandler: '%s' moved %s to the trash\n", u.Email, id)
+
+	jsonOut(w, r, map[string]interface{}{
+		"id":         a.Id,
+		"deleted_by": a.DeletedBy,
+		"deleted_at": a.DeletedAt,
+	})
 }
 
+// Scores are always attributed to the logged-in user. The email field
+// in the body may be left out, but if it names somebody else the whole
+// update is rejected unless an admin set on_behalf, which gets audited.
 func ScoreUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	if !checkAuth(w, r, false) {
+	u := authorize(w, r, PermScore)
+	if u == nil {
 		return
 	}
 	scores := make(ScoreUpdates, 7)
@@ -142,7 +256,67 @@ func ScoreUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = scores.Save(cass)
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load the rubric: %s", err), 500)
+		return
+	}
+
+	audits := make(ScoreAudits, 0)
+	for i := range scores {
+		su := &scores[i]
+		err = rubric.Check(su)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if su.Email == "" {
+			su.Email = Email(u.Email)
+		}
+
+		// nobody scores an abstract they have a conflict with, even
+		// when an admin is doing it for them
+		if recused(w, string(su.Email), su.Id) {
+			return
+		}
+
+		if su.Email == Email(u.Email) {
+			su.OnBehalf = false
+			continue
+		}
+
+		if !su.OnBehalf {
+			http.Error(w, fmt.Sprintf("score email '%s' does not match the logged-in user", su.Email), http.StatusForbidden)
+			return
+		}
+		if !u.Can(PermAdmin) {
+			http.Error(w, "only admins can score on behalf of another reviewer", http.StatusForbidden)
+			return
+		}
+
+		audits = append(audits, ScoreAudit{
+			AbsId: su.Id,
+			Id:    gocql.TimeUUID(),
+			Actor: Email(u.Email),
+			Email: su.Email,
+			Slot:  su.Slot,
+			Score: su.Score,
+		})
+	}
+
+	// write the audit trail first so nothing goes unrecorded
+	for _, sa := range audits {
+		err = db.SaveScoreAudit(&sa)
+		if err != nil {
+			log.Printf("score audit failed: %s\n", err)
+			http.Error(w, fmt.Sprintf("score audit failed: %s", err), 500)
+			return
+		}
+		log.Printf("ScoreUpdateHandler: '%s' set %s=%g for '%s' on %s\n", sa.Actor, sa.Slot, sa.Score, sa.Email, sa.AbsId)
+	}
+
+	err = scores.Save(db)
 	if err != nil {
 		log.Printf("score update failed: %s\n", err)
 		http.Error(w, fmt.Sprintf("score update failed: %s", err), 500)
@@ -152,8 +326,30 @@ func ScoreUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	jsonOut(w, r, scores)
 }
 
+// GET lists the on-behalf score changes for an abstract, admins only
+func ScoreAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermAdmin) == nil {
+		return
+	}
+
+	id, err := gocql.ParseUUID(mux.Vars(r)["abstract_id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+
+	alist, err := db.ListScoreAudits(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list score audits: %s", err), 500)
+		return
+	}
+
+	jsonOut(w, r, alist)
+}
+
 func CommentsHandler(w http.ResponseWriter, r *http.Request) {
-	if !checkAuth(w, r, false) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
 		return
 	}
 	c := Comment{}
@@ -166,7 +362,10 @@ func CommentsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 500)
 			return
 		}
-		clist, err := ListComments(cass, absid)
+		if recused(w, u.Email, absid) {
+			return
+		}
+		clist, err := db.ListComments(absid)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to list comments: %s", err), 500)
 			return
@@ -174,39 +373,73 @@ func CommentsHandler(w http.ResponseWriter, r *http.Request) {
 		jsonOut(w, r, clist)
 		return
 	} else if r.Method == "PUT" || r.Method == "PATCH" {
+		if !u.Can(PermScore) {
+			http.Error(w, "'score' permission required", http.StatusForbidden)
+			return
+		}
 		dec := json.NewDecoder(r.Body)
 		err := dec.Decode(&c)
 		if err != nil {
-			log.Printf("CommentsHandler/%s invalid json data: %s", r.Method, err)
-			http.Error(w, fmt.Sprintf("CommentsHandler/%s invalid json data: %s", r.Method, err), 500)
+			log.Printf("CommentsHandler/%s invalid json data: %s\n", r.Method, err)
+			http.Error(w, fmt.Sprintf("CommentsHandler/%s invalid json data: %s", r.Method, err), http.StatusBadRequest)
+			return
+		}
+		if recused(w, u.Email, c.AbsId) {
 			return
 		}
 	} else {
-		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), 500)
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
+	// comments are always by whoever is logged in, an admin editing
+	// someone else's keeps it theirs
+	c.Email = Email(u.Email)
 	if r.Method == "PUT" {
 		c.Id = gocql.TimeUUID()
+	} else {
+		old, err := db.FetchComment(c.AbsId, c.Id)
+		if err == ErrNotFound {
+			http.Error(w, fmt.Sprintf("comment '%s' not found", c.Id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("CommentsHandler db.FetchComment() failed: %s", err), 500)
+			return
+		}
+		if normalizeEmail(string(old.Email)) != u.Email && !u.Can(PermAdmin) {
+			http.Error(w, "only the comment's author or an admin can edit it", http.StatusForbidden)
+			return
+		}
+		c.Email = old.Email
 	}
 
 	// bare minimum input checking
-	if c.Email == "" || c.Body == "" {
+	if c.Body == "" {
 		log.Printf("CommentsHandler/%s required field missing\n", r.Method)
-		http.Error(w, "required field missing", 500)
+		http.Error(w, "required field missing", http.StatusBadRequest)
 		return
 	}
 
-	err := c.Save(cass)
+	err := db.SaveComment(&c)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("CommentHandler c.Save() failed: %s", err), 500)
+		http.Error(w, fmt.Sprintf("CommentHandler db.SaveComment() failed: %s", err), 500)
 		return
 	}
 
 	jsonOut(w, r, c)
 }
 
+// GET lists the admins
 func AdminsHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermAdmin) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
 	admins, err := fetchAdmins()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("AdminsHandler failed: %s", err), 500)
@@ -216,36 +449,132 @@ func AdminsHandler(w http.ResponseWriter, r *http.Request) {
 	jsonOut(w, r, admins)
 }
 
-// returns the email string if authenticated (via persona), it won't
-// be there at all if the user didn't authenticate
-func checkAuth(w http.ResponseWriter, r *http.Request, adminOnly bool) bool {
-	sess, err := store.Get(r, sessCookie)
+// PUT adds an admin, DELETE removes one
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	email, err := parseEmail(mux.Vars(r)["email"])
 	if err != nil {
-		log.Printf("failed to read cookie: %s\n", err)
-		return false
+		http.Error(w, fmt.Sprintf("invalid email address: %s", err), http.StatusBadRequest)
+		return
 	}
 
-	if sess.IsNew {
-		log.Printf("Saving session ID '%s' to Cassandra.\n", sess.ID)
-		sess.Save(r, w)
+	switch r.Method {
+	case "PUT":
+		err = db.SaveAdmin(email)
+	case "DELETE":
+		err = removeAdmin(email)
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
 	}
 
-	if sess.Values["email"] != nil {
-		email := sess.Values["email"].(string)
-		//log.Printf("sess.Values[email]: '%s'\n", sess.Values["email"])
-		if email != "" {
-			// authentication is successful, now check for admin status if that is requested
-			if adminOnly {
-				isAdmin, err := checkIfAdmin(email)
-				if err != nil {
-					log.Printf("admin check failed: %s\n", err)
-					return false
-				}
-				return isAdmin
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("'%s' is not an admin", email), http.StatusNotFound)
+		return
+	} else if err == ErrLastAdmin {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("AdminHandler/%s failed: %s", r.Method, err), 500)
+		return
+	}
+
+	log.Printf("AdminHandler: %s %s by '%s'\n", r.Method, email, u.Email)
+
+	jsonOut(w, r, map[string]string{"email": email})
+}
+
+// GET lists everybody in the reviewers table with their roles
+func ReviewersHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermAdmin) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rlist, err := db.ListReviewers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ReviewersHandler failed: %s", err), 500)
+		return
+	}
+
+	jsonOut(w, r, rlist)
+}
+
+// GET fetches one reviewer, PUT adds or updates one, DELETE removes one
+// PUT takes an optional body: { "role": "track_lead", "tracks": ["Operations"] }
+func ReviewerHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	email, err := parseEmail(mux.Vars(r)["email"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid email address: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rev, err := fetchReviewer(email)
+		if err == ErrNotFound {
+			http.Error(w, fmt.Sprintf("'%s' is not a reviewer", email), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("ReviewerHandler/GET failed: %s", err), 500)
+			return
+		}
+		jsonOut(w, r, rev)
+		return
+	case "PUT":
+		rev := Reviewer{}
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(r.Body).Decode(&rev)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("ReviewerHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+				return
 			}
-			return true
 		}
-	}
 
-	return false
+		if rev.Role == RoleNone {
+			rev.Role = RoleReviewer
+		}
+		// admins live in the admins table, see AdminHandler
+		if !rev.Role.Valid() || rev.Role == RoleAdmin {
+			http.Error(w, fmt.Sprintf("invalid role '%s'", rev.Role), http.StatusBadRequest)
+			return
+		}
+
+		rev.Email = email
+		rev.Added = time.Now()
+		err = db.SaveReviewer(&rev)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ReviewerHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("ReviewerHandler: PUT %s as %s by '%s'\n", email, rev.Role, u.Email)
+		jsonOut(w, r, rev)
+	case "DELETE":
+		// the row might be from before emails were lowercased
+		if rev, err := fetchReviewer(email); err == nil {
+			email = rev.Email
+		}
+		err = db.DeleteReviewer(email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ReviewerHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("ReviewerHandler: DELETE %s by '%s'\n", email, u.Email)
+		jsonOut(w, r, map[string]string{"email": email})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
 }
@@ -0,0 +1,140 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * handlers_test.go: the abstract, score and comment handlers on a MemStore
+ *
+ */
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+const testAbstract = `{"title":"Compaction","body":"all about it","authors":{"sp@z.org":"Speaker"}}`
+
+func TestAbstractsHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "rev@x", Role: RoleReviewer})
+	ms.SaveReviewer(&Reviewer{Email: "obs@x", Role: RoleObserver})
+	h := newRouter()
+	adm, rev, obs := loginAs(t, "adm@x"), loginAs(t, "rev@x"), loginAs(t, "obs@x")
+
+	rec := do(t, h, adm, "PUT", "/abstracts/", testAbstract)
+	if rec.Code != 200 {
+		t.Fatalf("PUT /abstracts/ = %d %s", rec.Code, rec.Body.String())
+	}
+	a := Abstract{}
+	json.Unmarshal(rec.Body.Bytes(), &a)
+	if a.Version != 1 || a.Created.IsZero() {
+		t.Errorf("PUT /abstracts/ = %+v", a)
+	}
+	stored, err := ms.FetchAbstract(a.Id)
+	if err != nil || stored.Title != "Compaction" {
+		t.Errorf("stored abstract = %+v %v", stored, err)
+	}
+
+	tests := []struct {
+		name   string
+		as     string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"list", "rev", "GET", "/abstracts/", "", 200},
+		{"fetch", "obs", "GET", "/abstracts/" + a.Id.String(), "", 200},
+		{"fetch missing", "rev", "GET", "/abstracts/" + gocql.TimeUUID().String(), "", 404},
+		{"reviewers can't add", "rev", "PUT", "/abstracts/", testAbstract, 403},
+		{"score", "rev", "POST", "/updatescores", `[{"id":"` + a.Id.String() + `","slot":"scores_a","score":2}]`, 200},
+		{"observers can't score", "obs", "POST", "/updatescores", `[{"id":"` + a.Id.String() + `","slot":"scores_a","score":2}]`, 403},
+		{"comment", "rev", "PUT", "/comments/", `{"abstract_id":"` + a.Id.String() + `","body":"nice"}`, 200},
+		{"observers can't comment", "obs", "PUT", "/comments/", `{"abstract_id":"` + a.Id.String() + `","body":"nice"}`, 403},
+		{"admins only", "rev", "GET", "/admins/", "", 403},
+	}
+	users := map[string]*http.Cookie{"adm": adm, "rev": rev, "obs": obs}
+	for _, tc := range tests {
+		rec := do(t, h, users[tc.as], tc.method, tc.path, tc.body)
+		if rec.Code != tc.code {
+			t.Errorf("%s: %s %s = %d, want %d: %s", tc.name, tc.method, tc.path, rec.Code, tc.code, rec.Body.String())
+		}
+	}
+
+	stored, _ = ms.FetchAbstract(a.Id)
+	if stored.ScoresA["rev@x"] != 2 {
+		t.Errorf("scores after POST /updatescores = %v", stored.ScoresA)
+	}
+	clist, _ := ms.ListComments(a.Id)
+	if len(clist) != 1 || clist[0].Email != "rev@x" {
+		t.Errorf("comments after PUT /comments/ = %v", clist)
+	}
+}
+
+func TestLoggedOut(t *testing.T) {
+	setupMem(t)
+	h := newRouter()
+	for _, path := range []string{"/abstracts/", "/comments/" + gocql.TimeUUID().String(), "/ranking/", "/admins/"} {
+		if rec := do(t, h, nil, "GET", path, ""); rec.Code != 401 {
+			t.Errorf("GET %s logged out = %d, want 401", path, rec.Code)
+		}
+	}
+}
+
+func TestEmailCase(t *testing.T) {
+	ms := setupMem(t)
+	// rows saved before emails were lowercased
+	ms.SaveAdmin("Adm@X")
+	ms.SaveReviewer(&Reviewer{Email: "Lead@Example.com", Role: RoleTrackLead})
+	h := newRouter()
+
+	tests := []struct {
+		login string
+		role  Role
+	}{
+		{"adm@x", RoleAdmin},
+		{"ADM@x", RoleAdmin},
+		{"lead@example.com", RoleTrackLead},
+		{"LEAD@EXAMPLE.COM", RoleTrackLead},
+		{"nobody@x", RoleNone},
+	}
+	for _, tc := range tests {
+		rec := do(t, h, loginAs(t, tc.login), "GET", "/whoami", "")
+		who := Whoami{}
+		json.Unmarshal(rec.Body.Bytes(), &who)
+		if who.Role != tc.role || who.Email != strings.ToLower(tc.login) {
+			t.Errorf("logged in as %s: /whoami = %s, want role '%s'", tc.login, rec.Body.String(), tc.role)
+		}
+	}
+
+	adm := loginAs(t, "adm@x")
+	if rec := do(t, h, adm, "GET", "/reviewers/LEAD@example.com", ""); rec.Code != 200 {
+		t.Errorf("GET /reviewers/ with different case = %d", rec.Code)
+	}
+	if rec := do(t, h, adm, "PUT", "/admins/New@X", ""); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"new@x"`) {
+		t.Errorf("PUT /admins/New@X = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(t, h, adm, "DELETE", "/admins/adm@x", ""); rec.Code != 200 {
+		t.Errorf("DELETE an admin saved with different case = %d %s", rec.Code, rec.Body.String())
+	}
+	if admins, _ := ms.ListAdmins(); len(admins) != 1 || admins[0] != "new@x" {
+		t.Errorf("admins = %v", admins)
+	}
+}
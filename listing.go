@@ -0,0 +1,465 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * listing.go: filtering, sorting and paging for GET /abstracts/
+ *
+ * Without a sort, pages come straight from the store in its own order
+ * using its paging state, so a page only reads about as many rows as it
+ * returns. Sorting needs every abstract, so sorted pages are cut from the
+ * full list and the cursor remembers the sort key of the last one.
+ * Either way the cursor for the next page is sent in the X-Next-Cursor
+ * header and the body is the same array as always.
+ *
+ */
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type listQuery struct {
+	tracks       []string
+	tag          Tag
+	status       map[Status]bool
+	reviewedByMe string // "", "true" or "false"
+	unreviewed   bool
+	sort         string // title, created or score, - in front for descending
+	limit        int    // 0 for everything
+	cursor       listCursor
+	summary      bool
+}
+
+// opaque to clients, base64 JSON
+type listCursor struct {
+	State   []byte     `json:"state,omitempty"` // store paging state for unsorted lists
+	Skip    int        `json:"skip,omitempty"`  // rows of that page already looked at
+	Size    int        `json:"size,omitempty"`  // and the page size it was read with
+	Sort    string     `json:"sort,omitempty"`  // sorted lists: the sort it belongs to
+	Title   string     `json:"title,omitempty"` // and the last abstract's sort key
+	Created time.Time  `json:"created,omitempty"`
+	Score   float64    `json:"score,omitempty"`
+	Id      gocql.UUID `json:"id,omitempty"`
+}
+
+// the view=summary projection, everything but the bodies and scores
+type AbstractSummary struct {
+	Id         gocql.UUID `json:"id"`
+	UpstreamId int        `json:"upstream_id"`
+	Title      string     `json:"title"`
+	Authors    Authors    `json:"authors"`
+	Company    string     `json:"company"`
+	Tracks     string     `json:"tracks"`
+	TrackIds   []string   `json:"track_ids"`
+	Tags       []Tag      `json:"tags"`
+	Created    time.Time  `json:"created"`
+	Status     Status     `json:"status"`
+	Version    int        `json:"version"`
+	Reviews    int        `json:"reviews"`  // reviewers who scored anything
+	Score      float64    `json:"score"`    // weighted rubric score, see ranking.go
+	Reviewed   bool       `json:"reviewed"` // by the logged-in user
+}
+
+// an abstract along with what the filters and sorts need to know
+type listItem struct {
+	a        Abstract
+	reviews  int
+	reviewed bool
+	score    float64
+}
+
+func encodeCursor(c listCursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (c listCursor, err error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(js, &c)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid cursor")
+	}
+	return
+}
+
+func parseListQuery(r *http.Request) (*listQuery, error) {
+	q := listQuery{
+		tracks:       splitTracks(r.FormValue("track")),
+		reviewedByMe: r.FormValue("reviewed_by_me"),
+		sort:         r.FormValue("sort"),
+	}
+
+	var err error
+	q.status, err = parseStatusFilter(r.FormValue("status"))
+	if err != nil {
+		return nil, err
+	}
+
+	if t := r.FormValue("tag"); t != "" {
+		q.tag, err = parseTag(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if q.reviewedByMe != "" {
+		b, err := strconv.ParseBool(q.reviewedByMe)
+		if err != nil {
+			return nil, fmt.Errorf("reviewed_by_me must be true or false")
+		}
+		q.reviewedByMe = strconv.FormatBool(b)
+	}
+	if u := r.FormValue("unreviewed"); u != "" {
+		q.unreviewed, err = strconv.ParseBool(u)
+		if err != nil {
+			return nil, fmt.Errorf("unreviewed must be true or false")
+		}
+	}
+
+	switch strings.TrimPrefix(q.sort, "-") {
+	case "", "title", "created", "score":
+	default:
+		return nil, fmt.Errorf("invalid sort '%s', must be title, created or score", q.sort)
+	}
+
+	switch r.FormValue("view") {
+	case "", "full":
+	case "summary":
+		q.summary = true
+	default:
+		return nil, fmt.Errorf("invalid view '%s', must be full or summary", r.FormValue("view"))
+	}
+
+	if l := r.FormValue("limit"); l != "" {
+		q.limit, err = strconv.Atoi(l)
+		if err != nil || q.limit < 1 || q.limit > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if c := r.FormValue("cursor"); c != "" {
+		q.cursor, err = decodeCursor(c)
+		if err != nil {
+			return nil, err
+		}
+		if q.cursor.Sort != q.sort {
+			return nil, fmt.Errorf("the cursor is for a different sort")
+		}
+		if q.cursor.Size < 0 || q.cursor.Size > maxPageSize {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if q.limit == 0 {
+			q.limit = defaultPageSize
+		}
+	}
+
+	return &q, nil
+}
+
+func (q *listQuery) match(it *listItem) bool {
+	if q.status != nil && !q.status[it.a.Status] {
+		return false
+	}
+	if len(q.tracks) > 0 {
+		// track= takes names or ids
+		u := User{Tracks: q.tracks}
+		found := false
+		for _, t := range append(splitTracks(it.a.Tracks), it.a.TrackIds...) {
+			if u.hasTrack(t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.tag != "" {
+		found := false
+		for _, t := range it.a.Tags {
+			if t == q.tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.reviewedByMe != "" && strconv.FormatBool(it.reviewed) != q.reviewedByMe {
+		return false
+	}
+	if q.unreviewed && it.reviews > 0 {
+		return false
+	}
+	return true
+}
+
+// less orders two items by the query's sort, ties go by id so the
+// order is stable from page to page
+func (q *listQuery) less(x, y *listCursor) bool {
+	field := strings.TrimPrefix(q.sort, "-")
+	desc := strings.HasPrefix(q.sort, "-")
+
+	var c int
+	switch field {
+	case "title":
+		c = strings.Compare(strings.ToLower(x.Title), strings.ToLower(y.Title))
+	case "created":
+		switch {
+		case x.Created.Before(y.Created):
+			c = -1
+		case x.Created.After(y.Created):
+			c = 1
+		}
+	case "score":
+		switch {
+		case x.Score < y.Score:
+			c = -1
+		case x.Score > y.Score:
+			c = 1
+		}
+	}
+	if desc {
+		c = -c
+	}
+	if c != 0 {
+		return c < 0
+	}
+	return x.Id.String() < y.Id.String()
+}
+
+func (q *listQuery) key(it *listItem) listCursor {
+	return listCursor{Sort: q.sort, Title: it.a.Title, Created: it.a.Created, Score: it.score, Id: it.a.Id}
+}
+
+// lister turns abstracts into what u is allowed to see
+type lister struct {
+	u      *User
+	blind  bool
+	ci     *conflictIndex
+	rubric Rubric
+}
+
+func newLister(u *User, ev *Event) (*lister, error) {
+	ci, err := loadConflictIndex()
+	if err != nil {
+		return nil, err
+	}
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		return nil, err
+	}
+	return &lister{u: u, blind: ev.hidesIdentity(u), ci: ci, rubric: rubric}, nil
+}
+
+func (l *lister) item(a Abstract) listItem {
+	// the aggregates leave out reviewers with a conflict, the same as
+	// /ranking/, this has to happen before the identity is hidden
+	clean := dropConflicted(Abstracts{a}, l.ci)[0]
+	if _, ok := l.ci.check(l.u.Email, &a); ok {
+		clean.hideScores()
+	}
+
+	it := listItem{}
+	reviewers := make(map[Email]bool)
+	for _, scores := range clean.Scores {
+		for email := range scores {
+			reviewers[email] = true
+		}
+	}
+	it.reviewed = reviewers[Email(l.u.Email)]
+	it.reviews = len(reviewers)
+	it.score = rankAbstract(&clean, l.rubric, NormRaw).Weighted
+
+	// admins get every score, everybody else only what went into the
+	// aggregates so their own totals come out the same
+	if !l.u.Can(PermAdmin) {
+		a = clean
+	} else if _, ok := l.ci.check(l.u.Email, &a); ok {
+		a.hideScores()
+	}
+	if l.blind {
+		a.hideIdentity()
+	}
+	it.a = a
+
+	return it
+}
+
+func (it *listItem) summary() AbstractSummary {
+	return AbstractSummary{
+		Id:         it.a.Id,
+		UpstreamId: it.a.UpstreamId,
+		Title:      it.a.Title,
+		Authors:    it.a.Authors,
+		Company:    it.a.Company,
+		Tracks:     it.a.Tracks,
+		TrackIds:   it.a.TrackIds,
+		Tags:       it.a.Tags,
+		Created:    it.a.Created,
+		Status:     it.a.Status,
+		Version:    it.a.Version,
+		Reviews:    it.reviews,
+		Score:      it.score,
+		Reviewed:   it.reviewed,
+	}
+}
+
+// sortedPage reads everything, then sorts and cuts out the page after
+// the cursor
+func (l *lister) sortedPage(q *listQuery) ([]listItem, *listCursor, error) {
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]listItem, 0, len(alist))
+	for _, a := range alist {
+		it := l.item(a)
+		if q.match(&it) {
+			items = append(items, it)
+		}
+	}
+
+	if q.sort != "" {
+		sort.SliceStable(items, func(i, j int) bool {
+			x, y := q.key(&items[i]), q.key(&items[j])
+			return q.less(&x, &y)
+		})
+	}
+	if q.limit == 0 {
+		return items, nil, nil
+	}
+
+	start := 0
+	if q.cursor.Sort != "" {
+		start = sort.Search(len(items), func(i int) bool {
+			k := q.key(&items[i])
+			return q.less(&q.cursor, &k)
+		})
+	}
+	items = items[start:]
+	if len(items) <= q.limit {
+		return items, nil, nil
+	}
+
+	items = items[:q.limit]
+	next := q.key(&items[q.limit-1])
+	return items, &next, nil
+}
+
+// storePage walks the store's pages until it has a full page of matches
+func (l *lister) storePage(q *listQuery) ([]listItem, *listCursor, error) {
+	items := make([]listItem, 0, q.limit)
+	state, skip := q.cursor.State, q.cursor.Skip
+
+	// skip counts rows of a page read with the cursor's size, the limit
+	// may have changed since so only later pages use it
+	size := q.limit
+	if q.cursor.Size > 0 {
+		size = q.cursor.Size
+	}
+
+	for {
+		alist, next, err := db.PageAbstracts(size, state)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i := skip; i < len(alist); i++ {
+			it := l.item(alist[i])
+			if !q.match(&it) {
+				continue
+			}
+			items = append(items, it)
+			if len(items) == q.limit {
+				// pick up where this left off, on this page or the next
+				if i+1 < len(alist) {
+					return items, &listCursor{State: state, Skip: i + 1, Size: size}, nil
+				}
+				if next != nil {
+					return items, &listCursor{State: next}, nil
+				}
+				return items, nil, nil
+			}
+		}
+
+		if next == nil {
+			return items, nil, nil
+		}
+		state, skip, size = next, 0, q.limit
+	}
+}
+
+// listAbstracts is GET /abstracts/, see the README for the parameters
+func listAbstracts(w http.ResponseWriter, r *http.Request, u *User, ev *Event) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l, err := newLister(u, ev)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list abstracts: %s", err), 500)
+		return
+	}
+
+	var items []listItem
+	var next *listCursor
+	if q.sort == "" && q.limit > 0 {
+		items, next, err = l.storePage(q)
+	} else {
+		items, next, err = l.sortedPage(q)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list abstracts: %s", err), 500)
+		return
+	}
+
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", encodeCursor(*next))
+	}
+
+	if q.summary {
+		out := make([]AbstractSummary, len(items))
+		for i := range items {
+			out[i] = items[i].summary()
+		}
+		jsonOut(w, r, out)
+		return
+	}
+
+	out := make(Abstracts, len(items))
+	for i := range items {
+		out[i] = items[i].a
+	}
+	jsonOut(w, r, out)
+}
@@ -0,0 +1,207 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * listing_test.go: cursors and paging through GET /abstracts/
+ *
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestListCursor(t *testing.T) {
+	tests := []listCursor{
+		{},
+		{State: []byte{0, 1, 2, 0xff}, Skip: 7, Size: 20},
+		{Sort: "-title", Title: "Ünïcode & \"quotes\"", Id: gocql.TimeUUID()},
+		{Sort: "created", Created: time.Date(2014, 9, 1, 12, 30, 0, 123456789, time.UTC), Id: gocql.TimeUUID()},
+		{Sort: "-score", Score: 0.1 + 0.2, Id: gocql.TimeUUID()},
+	}
+
+	for _, c := range tests {
+		s := encodeCursor(c)
+		if strings.ContainsAny(s, "+/=") {
+			t.Errorf("cursor '%s' isn't safe in a URL", s)
+		}
+		got, err := decodeCursor(s)
+		if err != nil || !bytes.Equal(got.State, c.State) || got.Skip != c.Skip || got.Size != c.Size || got.Sort != c.Sort ||
+			got.Title != c.Title || !got.Created.Equal(c.Created) || got.Score != c.Score || got.Id != c.Id {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v %v", c, got, err)
+		}
+	}
+
+	for _, bad := range []string{"!!!", "bm90IGpzb24", "e30=x"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) didn't fail", bad)
+		}
+	}
+}
+
+// pages follows X-Next-Cursor from the first page to the last and
+// returns the ids in the order they came
+func pages(t *testing.T, h http.Handler, c *http.Cookie, query string) []gocql.UUID {
+	t.Helper()
+	ids := make([]gocql.UUID, 0)
+	path := "/abstracts/?view=summary&" + query
+	for n := 0; n < 100; n++ {
+		rec := do(t, h, c, "GET", path, "")
+		if rec.Code != 200 {
+			t.Fatalf("GET %s = %d %s", path, rec.Code, rec.Body.String())
+		}
+		page := []AbstractSummary{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		for _, s := range page {
+			ids = append(ids, s.Id)
+		}
+
+		next := rec.Header().Get("X-Next-Cursor")
+		if next == "" {
+			return ids
+		}
+		path = "/abstracts/?view=summary&" + query + "&cursor=" + next
+	}
+	t.Fatalf("GET /abstracts/?%s never ran out of pages", query)
+	return nil
+}
+
+func TestListPaging(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	created := time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 23; i++ {
+		// pairs of abstracts share a title and created time so the ties
+		// have to be broken by id
+		a := Abstract{
+			Id:      gocql.TimeUUID(),
+			Title:   fmt.Sprintf("talk %02d", i/2),
+			Created: created.Add(time.Duration(i/2) * time.Hour),
+		}
+		ms.SaveAbstract(&a)
+		if i%3 == 0 {
+			a.Status = StatusWithdrawn
+			ms.SaveStatus(&a)
+		}
+	}
+	h, rev := newRouter(), loginAs(t, "rev@x")
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"limit=5", 23},
+		{"limit=1", 23},
+		{"limit=4&status=withdrawn", 8},
+		{"limit=5&sort=title", 23},
+		{"limit=3&sort=-title", 23},
+		{"limit=4&sort=created&status=submitted", 15},
+		{"limit=7&sort=-score", 23},
+		{"limit=500", 23},
+	}
+	for _, tc := range tests {
+		ids := pages(t, h, rev, tc.query)
+		seen := make(map[gocql.UUID]bool)
+		for _, id := range ids {
+			seen[id] = true
+		}
+		if len(ids) != tc.count || len(seen) != tc.count {
+			t.Errorf("%s: %d abstracts over all pages, %d different, want %d", tc.query, len(ids), len(seen), tc.count)
+		}
+
+		// sorted pages put together are the same as one sorted page
+		if strings.Contains(tc.query, "sort=") {
+			whole := pages(t, h, rev, tc.query[strings.Index(tc.query, "&")+1:])
+			if fmt.Sprint(ids) != fmt.Sprint(whole) {
+				t.Errorf("%s: pages = %v, all at once = %v", tc.query, ids, whole)
+			}
+		}
+	}
+
+	// a different limit on every page still gets everything once
+	for _, query := range []string{"", "status=submitted"} {
+		seen := make(map[gocql.UUID]bool)
+		path, n := "/abstracts/?view=summary&limit=5&"+query, 0
+		for limit := 2; path != ""; limit = limit%9 + 1 {
+			rec := do(t, h, rev, "GET", path, "")
+			page := []AbstractSummary{}
+			json.Unmarshal(rec.Body.Bytes(), &page)
+			for _, s := range page {
+				seen[s.Id] = true
+				n++
+			}
+			path = ""
+			if next := rec.Header().Get("X-Next-Cursor"); next != "" {
+				path = fmt.Sprintf("/abstracts/?view=summary&limit=%d&%s&cursor=%s", limit, query, next)
+			}
+		}
+		if want := map[string]int{"": 23, "status=submitted": 15}[query]; n != want || len(seen) != want {
+			t.Errorf("%q with changing limits: %d abstracts, %d different, want %d", query, n, len(seen), want)
+		}
+	}
+
+	c := encodeCursor(listCursor{Sort: "title", Title: "talk 05"})
+	big := encodeCursor(listCursor{Skip: 1, Size: maxPageSize + 1})
+	for _, query := range []string{"cursor=" + c + "&sort=created", "cursor=" + c, "cursor=garbage", "cursor=" + big} {
+		if rec := do(t, h, rev, "GET", "/abstracts/?"+query, ""); rec.Code != 400 {
+			t.Errorf("GET /abstracts/?%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestListConflictedScores(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	ms.SaveReviewer(&Reviewer{Email: "friend@x"})
+	id := gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: id, Title: "t"})
+	ms.SaveScore(&ScoreUpdate{Id: id, Slot: "scores_a", Email: "rev@x", Score: 1})
+	ms.SaveScore(&ScoreUpdate{Id: id, Slot: "scores_a", Email: "friend@x", Score: 3})
+	ms.SaveConflict(&Conflict{AbsId: id, Email: "friend@x", Source: ConflictManual})
+	h := newRouter()
+
+	tests := []struct {
+		as     string
+		scores int
+	}{
+		{"rev@x", 1},
+		{"adm@x", 2},
+		{"friend@x", 0},
+	}
+	for _, tc := range tests {
+		c := loginAs(t, tc.as)
+		for _, path := range []string{"/abstracts/", "/abstracts/" + id.String()} {
+			rec := do(t, h, c, "GET", path, "")
+			body := rec.Body.String()
+			if strings.HasPrefix(body, "{") {
+				body = "[" + body + "]"
+			}
+			alist := Abstracts{}
+			json.Unmarshal([]byte(body), &alist)
+			if rec.Code != 200 || len(alist) != 1 || len(alist[0].ScoresA) != tc.scores || len(alist[0].Scores["scores_a"]) != tc.scores {
+				t.Errorf("%s: GET %s = %d %s, want %d scores", tc.as, path, rec.Code, rec.Body.String(), tc.scores)
+			}
+		}
+	}
+}
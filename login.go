@@ -0,0 +1,87 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * login.go: session handling shared by the login methods
+ *
+ */
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// setSessionEmail marks the session as logged in as email, this is
+// the last step of every login method. The session gets a new ID so
+// one fixed before login can't be used to ride along.
+func setSessionEmail(w http.ResponseWriter, r *http.Request, email string) error {
+	sess, err := store.Get(r, sessCookie)
+	if err != nil {
+		log.Printf("Error loading session for email '%s': %s\n", email, err)
+		return err
+	}
+	err = store.Renew(sess)
+	if err != nil {
+		return err
+	}
+	sess.Values["email"] = normalizeEmail(email)
+	return sess.Save(r, w)
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Enter: LogoutHandler()\n")
+	sess, err := store.Get(r, sessCookie)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read cookie: %s\n", err), 500)
+		return
+	}
+	err = store.Delete(r, w, sess)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to delete session: %s\n", err), 500)
+	}
+
+	log.Printf("Exit: LogoutHandler()\n")
+}
+
+type Whoami struct {
+	User
+	Perms  []Perm   `json:"perms"`  // what the UI should enable
+	Logins []string `json:"logins"` // login methods that are configured
+}
+
+// WhoamiHandler tells the UI who is logged in, what they're allowed to
+// do, and how to log in. Email is "" for anonymous.
+// The UI uses this to decide what to show, the handlers still check
+// everything themselves.
+func WhoamiHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := sessionUser(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("WhoamiHandler failed: %s", err), 500)
+		return
+	}
+
+	who := Whoami{User: *u, Perms: u.Perms(), Logins: make([]string, 0)}
+
+	if idp != nil {
+		who.Logins = append(who.Logins, "oidc")
+	}
+	if mailer != nil {
+		who.Logins = append(who.Logins, "email")
+	}
+
+	jsonOut(w, r, who)
+}
@@ -0,0 +1,220 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * magiclink.go: passwordless login by emailing a single-use link
+ *
+ * The link carries a random secret plus an HMAC of it so junk can be
+ * rejected without a DB lookup. Only a hash of the secret is stored,
+ * and it's deleted the first time it's used.
+ *
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type LoginToken struct {
+	Id      string    // sha256 of the secret in the link, hex encoded
+	Email   string    // who the link was sent to
+	Expires time.Time // links are useless after this
+}
+
+type Mailer struct {
+	Addr string // host:port of the SMTP server
+	From string
+	User string // optional, PLAIN auth is used when set
+	Pass string
+}
+
+func (m *Mailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.User != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.User, m.Pass, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, to, subject, time.Now().Format(time.RFC1123Z), body)
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg))
+}
+
+var magicConfirmTmpl = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><title>CFP Review Login</title></head>
+<body>
+<form method="POST" action="/login/email/confirm">
+  <input type="hidden" name="t" value="{{.}}">
+  <button type="submit">Log in to the CFP review</button>
+</form>
+</body>
+</html>
+`))
+
+func magicSig(secret string) string {
+	mac := hmac.New(sha256.New, privKey)
+	mac.Write([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func magicTokenId(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checks the signature on a link token and returns the secret part
+func parseMagicToken(token string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(magicSig(parts[0])), []byte(parts[1])) {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// MagicLinkRequestHandler emails a login link to allow-listed reviewers.
+// The response is the same whether or not a mail was sent so it can't
+// be used to find out who's on the list.
+func MagicLinkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if mailer == nil {
+		http.Error(w, "email login is not configured", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	email, err := parseEmail(r.FormValue("email"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid email address: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := checkIfAllowed(email)
+	if err != nil {
+		log.Printf("MagicLinkRequestHandler: allow-list check for '%s' failed: %s\n", email, err)
+		http.Error(w, "failed to send login link", 500)
+		return
+	}
+
+	if allowed {
+		err = sendMagicLink(email)
+		if err != nil {
+			log.Printf("MagicLinkRequestHandler: sending link to '%s' failed: %s\n", email, err)
+			http.Error(w, "failed to send login link", 500)
+			return
+		}
+	} else {
+		log.Printf("MagicLinkRequestHandler: '%s' is not on the allow-list, not sending a link\n", email)
+	}
+
+	jsonOut(w, r, map[string]string{"status": "sent"})
+}
+
+func sendMagicLink(email string) error {
+	secret, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	lt := LoginToken{
+		Id:      magicTokenId(secret),
+		Email:   email,
+		Expires: time.Now().Add(magicTTL),
+	}
+	err = db.SaveLoginToken(&lt)
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(baseURL, "/") + "/login/email/confirm?t=" + secret + "." + magicSig(secret)
+	body := fmt.Sprintf("Use this link to log in to the CFP review. It can only be used once\n"+
+		"and expires in %s.\n\n%s\n\nIf you didn't ask for this, you can ignore this email.\n", magicTTL, link)
+
+	return mailer.Send(email, "Your CFP review login link", body)
+}
+
+// MagicLinkHandler logs in with a token from an emailed link. GET only
+// shows a button that POSTs the token back, otherwise mail scanners that
+// follow links would burn the token before the reviewer gets to it.
+func MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if mailer == nil {
+		http.Error(w, "email login is not configured", http.StatusNotFound)
+		return
+	}
+
+	token := r.FormValue("t")
+	secret, ok := parseMagicToken(token)
+	if !ok {
+		http.Error(w, "invalid login link", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		magicConfirmTmpl.Execute(w, token)
+		return
+	case "POST":
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	lt, err := db.ConsumeLoginToken(magicTokenId(secret))
+	if err == ErrNotFound {
+		http.Error(w, "this login link has already been used or has expired", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Printf("MagicLinkHandler: consuming token failed: %s\n", err)
+		http.Error(w, "failed to check login link", 500)
+		return
+	}
+
+	if time.Now().After(lt.Expires) {
+		http.Error(w, "this login link has already been used or has expired", http.StatusUnauthorized)
+		return
+	}
+
+	err = setSessionEmail(w, r, lt.Email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to save session: %s", err), 500)
+		return
+	}
+
+	log.Printf("MagicLinkHandler: '%s' logged in\n", lt.Email)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
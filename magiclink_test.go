@@ -0,0 +1,285 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * magiclink_test.go: emailed login links through a fake SMTP server
+ *
+ */
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMail struct {
+	to   string
+	data string
+}
+
+// testSMTP speaks just enough SMTP for net/smtp.SendMail. Mail is on
+// the channel by the time SendMail returns.
+type testSMTP struct {
+	ln   net.Listener
+	mail chan testMail
+}
+
+func newTestSMTP(t *testing.T) *testSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSMTP{ln: ln, mail: make(chan testMail, 10)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *testSMTP) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(line string) { c.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost test")
+	m := testMail{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.to = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				b.WriteString(strings.TrimPrefix(l, "."))
+			}
+			m.data = b.String()
+			s.mail <- m
+			m = testMail{}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func setupMagic(t *testing.T) *testSMTP {
+	t.Helper()
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@example.com"})
+	s := newTestSMTP(t)
+	mailer = &Mailer{Addr: s.ln.Addr().String(), From: "cfp@example.com"}
+	baseURL = "http://cfp.example.com/"
+	magicTTL = time.Minute
+	t.Cleanup(func() { mailer = nil })
+	return s
+}
+
+func form(method, path string, v url.Values) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+var linkRe = regexp.MustCompile(`http://cfp\.example\.com/login/email/confirm\?t=\S+`)
+
+// requestLink asks for a link for email and returns the one mailed
+func requestLink(t *testing.T, s *testSMTP, email string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	MagicLinkRequestHandler(rec, form("POST", "/login/email", url.Values{"email": {email}}))
+	if rec.Code != 200 {
+		t.Fatalf("POST /login/email = %d %s", rec.Code, rec.Body.String())
+	}
+
+	select {
+	case m := <-s.mail:
+		if m.to != email {
+			t.Errorf("link mailed to '%s', want '%s'", m.to, email)
+		}
+		link := linkRe.FindString(m.data)
+		if link == "" {
+			t.Fatalf("no link in the mail: %s", m.data)
+		}
+		return link
+	default:
+		t.Fatal("no mail was sent")
+	}
+	return ""
+}
+
+func confirm(token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	MagicLinkHandler(rec, form("POST", "/login/email/confirm", url.Values{"t": {token}}))
+	return rec
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	s := setupMagic(t)
+	link := requestLink(t, s, "rev@example.com")
+	u, _ := url.Parse(link)
+	token := u.Query().Get("t")
+
+	// following the link only shows the button, a mail scanner doing
+	// this doesn't use up the token
+	rec := httptest.NewRecorder()
+	MagicLinkHandler(rec, httptest.NewRequest("GET", link, nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), token) {
+		t.Fatalf("GET link = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("GET link set a cookie")
+	}
+
+	rec = confirm(token)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("POST link = %d %s", rec.Code, rec.Body.String())
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessCookie {
+			session = c
+		}
+	}
+	who := do(t, newRouter(), session, "GET", "/whoami", "")
+	if !strings.Contains(who.Body.String(), `"rev@example.com"`) {
+		t.Errorf("/whoami after login = %s", who.Body.String())
+	}
+
+	if rec := confirm(token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reusing the link = %d, want 401", rec.Code)
+	}
+}
+
+func TestMagicLinkNotAllowed(t *testing.T) {
+	s := setupMagic(t)
+	rec := httptest.NewRecorder()
+	MagicLinkRequestHandler(rec, form("POST", "/login/email", url.Values{"email": {"nobody@example.com"}}))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"sent"`) {
+		t.Errorf("POST /login/email for a stranger = %d %s, want the same as for a reviewer", rec.Code, rec.Body.String())
+	}
+	if len(s.mail) != 0 {
+		t.Errorf("mailed a link to a stranger: %+v", <-s.mail)
+	}
+}
+
+func TestMagicLinkExpired(t *testing.T) {
+	s := setupMagic(t)
+	magicTTL = -time.Second
+	u, _ := url.Parse(requestLink(t, s, "rev@example.com"))
+	if rec := confirm(u.Query().Get("t")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired link = %d, want 401", rec.Code)
+	}
+}
+
+func TestMagicLinkTampered(t *testing.T) {
+	s := setupMagic(t)
+	u, _ := url.Parse(requestLink(t, s, "rev@example.com"))
+	token := u.Query().Get("t")
+	secret := strings.Split(token, ".")[0]
+
+	for _, bad := range []string{
+		"",
+		"nodot",
+		"abc.def",
+		secret + ".",
+		secret + "x." + magicSig(secret),
+		token + ".extra",
+	} {
+		if rec := confirm(bad); rec.Code != http.StatusBadRequest {
+			t.Errorf("token %q = %d, want 400", bad, rec.Code)
+		}
+	}
+
+	// none of that used up the real one
+	if rec := confirm(token); rec.Code != http.StatusFound {
+		t.Errorf("real token after the bad ones = %d", rec.Code)
+	}
+}
+
+func TestMagicLinkNewSession(t *testing.T) {
+	s := setupMagic(t)
+	h := newRouter()
+
+	// an anonymous session, e.g. one an attacker planted in the browser
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/whoami", nil))
+	var planted *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessCookie {
+			planted = c
+		}
+	}
+	if planted == nil {
+		t.Fatal("GET /whoami didn't start a session")
+	}
+
+	u, _ := url.Parse(requestLink(t, s, "rev@example.com"))
+	req := form("POST", "/login/email/confirm", url.Values{"t": {u.Query().Get("t")}})
+	req.AddCookie(planted)
+	rec = httptest.NewRecorder()
+	MagicLinkHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("POST link = %d %s", rec.Code, rec.Body.String())
+	}
+
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessCookie {
+			session = c
+		}
+	}
+	if session == nil || session.Value == planted.Value {
+		t.Fatal("logging in kept the session from before")
+	}
+	if who := do(t, h, planted, "GET", "/whoami", ""); strings.Contains(who.Body.String(), "rev@example.com") {
+		t.Errorf("the planted session is logged in: %s", who.Body.String())
+	}
+	if who := do(t, h, session, "GET", "/whoami", ""); !strings.Contains(who.Body.String(), `"rev@example.com"`) {
+		t.Errorf("/whoami after login = %s", who.Body.String())
+	}
+}
@@ -24,55 +24,168 @@ import (
 	"fmt"
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 var privKey []byte
 var store *CQLStore
-var addrFlag, cqlFlag, ksFlag, sessCookie, audience, keyFlag string
-var cass *gocql.Session
+var db Store
+var idp *OIDCProvider
+var addrFlag, cqlFlag, ksFlag, sessCookie, keyFlag, storeFlag string
+var oidcIssuer, oidcClientId, oidcSecret, oidcRedirect string
+var mailer *Mailer
+var smtpAddr, smtpFrom, smtpUser, smtpPass, baseURL string
+var magicTTL time.Duration
+var addAdminFlag string
+var eventFlag string
 
 func init() {
 	flag.StringVar(&addrFlag, "addr", ":8080", "IP:PORT or :PORT address to listen on")
 	flag.StringVar(&cqlFlag, "cql", "127.0.0.1", "IP or IP:port of the Cassandra CQL service")
 	flag.StringVar(&ksFlag, "ks", "ccfp", "keyspace containing the ccfp schema")
 	flag.StringVar(&sessCookie, "cookie", "summitcfp", "the name of the cookie, publicly visible")
-	flag.StringVar(&audience, "audience", "localhost:8080", "the domain:port value for 'audience' in Mozilla Persona")
 	flag.StringVar(&keyFlag, "key", "INSECURE", "a private key for encrypted session storage")
+	flag.StringVar(&storeFlag, "store", "cassandra", "where to keep data: cassandra, memory, or file:/path/to/db")
+	flag.StringVar(&oidcIssuer, "oidc-issuer", "", "OpenID Connect issuer URL, e.g. https://accounts.google.com")
+	flag.StringVar(&oidcClientId, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&oidcSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&oidcRedirect, "oidc-redirect", "http://localhost:8080/login/callback", "OpenID Connect redirect URL, must be registered with the IdP")
+	flag.StringVar(&smtpAddr, "smtp", "", "host:port of an SMTP server for emailed login links, empty disables them")
+	flag.StringVar(&smtpFrom, "smtp-from", "cfp@localhost", "From address for emailed login links")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP username, optional")
+	flag.StringVar(&smtpPass, "smtp-pass", "", "SMTP password, optional")
+	flag.StringVar(&baseURL, "base-url", "http://localhost:8080", "public URL of this app, used to build emailed login links")
+	flag.DurationVar(&magicTTL, "magic-ttl", 15*time.Minute, "how long emailed login links are valid")
+	flag.StringVar(&eventFlag, "event", "cfp", "name of the event being reviewed, selects the scoring rubric")
+	flag.StringVar(&addAdminFlag, "add-admin", "", "add this email address as an admin on startup, for bootstrapping")
 }
 
 func main() {
 	flag.Parse()
 	privKey = []byte(keyFlag)
 
-	// connect to Cassandra
-	cluster := gocql.NewCluster(cqlFlag)
-	cluster.Keyspace = ksFlag
-	cluster.Consistency = gocql.Quorum
+	switch {
+	case storeFlag == "cassandra":
+		// connect to Cassandra
+		cluster := gocql.NewCluster(cqlFlag)
+		cluster.Keyspace = ksFlag
+		cluster.Consistency = gocql.Quorum
 
-	var err error
-	cass, err = cluster.CreateSession()
-	if err != nil {
-		panic(fmt.Sprintf("Error creating Cassandra session: %v", err))
+		cass, err := cluster.CreateSession()
+		if err != nil {
+			panic(fmt.Sprintf("Error creating Cassandra session: %v", err))
+		}
+		defer cass.Close()
+
+		db = NewCassandraStore(cass)
+	case storeFlag == "memory":
+		log.Printf("Using in-memory storage, nothing will be saved on exit.\n")
+		db = NewMemStore()
+	case strings.HasPrefix(storeFlag, "file:"):
+		bs, err := NewBoltStore(strings.TrimPrefix(storeFlag, "file:"))
+		if err != nil {
+			log.Fatalf("Error opening database file: %s\n", err)
+		}
+		defer bs.Close()
+
+		db = bs
+	default:
+		log.Fatalf("Invalid -store '%s', must be cassandra, memory, or file:/path.\n", storeFlag)
+	}
+
+	store = NewCQLStore(db, privKey)
+
+	if addAdminFlag != "" {
+		email, err := parseEmail(addAdminFlag)
+		if err != nil {
+			log.Fatalf("Invalid -add-admin address: %s\n", err)
+		}
+		err = db.SaveAdmin(email)
+		if err != nil {
+			log.Fatalf("Failed to add admin '%s': %s\n", email, err)
+		}
+		log.Printf("Added '%s' as an admin.\n", email)
+	}
+
+	if oidcIssuer != "" {
+		var err error
+		idp, err = NewOIDCProvider(oidcIssuer, oidcClientId, oidcSecret, oidcRedirect, nil)
+		if err != nil {
+			log.Fatalf("OpenID Connect setup failed: %s\n", err)
+		}
+	} else {
+		log.Printf("-oidc-issuer is not set, OpenID Connect login is disabled.\n")
 	}
-	defer cass.Close()
 
-	store = NewCQLStore(cass, privKey)
+	if smtpAddr != "" {
+		mailer = &Mailer{Addr: smtpAddr, From: smtpFrom, User: smtpUser, Pass: smtpPass}
+	} else {
+		log.Printf("-smtp is not set, emailed login links are disabled.\n")
+	}
 
+	http.Handle("/", newRouter())
+
+	http.ListenAndServe(addrFlag, nil)
+}
+
+// all of the app's routes, split out of main() so the handlers
+// can be exercised without a listening server
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", RootHandler)
 	r.HandleFunc("/index.html", RootHandler)
 	r.HandleFunc("/admins/", AdminsHandler)
+	r.HandleFunc("/admins/{email}", AdminHandler)
+	r.HandleFunc("/reviewers/", ReviewersHandler)
+	r.HandleFunc("/reviewers/{email}", ReviewerHandler)
+	r.HandleFunc("/speakers/", SpeakersHandler)
+	r.HandleFunc("/speakers/{email}", SpeakerHandler)
 	r.HandleFunc("/abstracts/", AbstractsHandler)
 	r.HandleFunc("/comments/", CommentsHandler)
 	r.HandleFunc("/comments/{abstract_id:[-a-f0-9]+}", CommentsHandler)
 	r.HandleFunc("/updatescores", ScoreUpdateHandler)
+	r.HandleFunc("/event", EventHandler)
+	r.HandleFunc("/rubric/", RubricHandler)
+	r.HandleFunc("/rubric/{name}", CriterionHandler)
+	r.HandleFunc("/tracks/", TracksHandler)
+	r.HandleFunc("/tracks/stats", TrackStatsHandler)
+	r.HandleFunc("/tracks/{id}", TrackHandler)
+	r.HandleFunc("/tracks/{id}/stats", TrackStatsHandler)
+	r.HandleFunc("/tracks/{id}/abstracts/", TrackAbstractsHandler)
+	r.HandleFunc("/ranking/", RankingHandler)
+	r.HandleFunc("/agreement/", AgreementHandler)
+	r.HandleFunc("/assignments/", AssignmentsHandler)
+	r.HandleFunc("/assignments/{abstract_id:[-a-f0-9]+}/{email}", AssignmentHandler)
+	r.HandleFunc("/queue/", QueueHandler)
+	r.HandleFunc("/conflicts/", ConflictsHandler)
+	r.HandleFunc("/conflicts/{abstract_id:[-a-f0-9]+}/{email}", ConflictHandler)
+	r.HandleFunc("/search/", SearchHandler)
+	r.HandleFunc("/tags/", TagsHandler)
+	r.HandleFunc("/tags/{tag}", TagHandler)
+	r.HandleFunc("/trash/", TrashHandler)
+	r.HandleFunc("/trash/{id:[-a-f0-9]+}", TrashedAbstractHandler)
+	r.HandleFunc("/trash/{id:[-a-f0-9]+}/restore", RestoreAbstractHandler)
+	r.HandleFunc("/scoreaudit/{abstract_id:[-a-f0-9]+}", ScoreAuditHandler)
 	r.HandleFunc("/login", LoginHandler)
+	r.HandleFunc("/login/callback", LoginCallbackHandler)
+	r.HandleFunc("/login/email", MagicLinkRequestHandler)
+	r.HandleFunc("/login/email/confirm", MagicLinkHandler)
+	r.HandleFunc("/whoami", WhoamiHandler)
 	r.HandleFunc("/logout", LogoutHandler)
 
+	r.HandleFunc("/abstracts/{id:[-a-f0-9]+}/status", StatusHandler)
+	r.HandleFunc("/abstracts/{id:[-a-f0-9]+}/revisions/", RevisionsHandler)
+	r.HandleFunc("/abstracts/{id:[-a-f0-9]+}/revisions/{rev:[-a-f0-9]+}/restore", RestoreRevisionHandler)
+	r.HandleFunc("/abstracts/{id:[-a-f0-9]+}/diff", RevisionDiffHandler)
+	r.HandleFunc("/abstracts/{id:[-a-f0-9]+}/tags/{tag}", AbstractTagHandler)
+
 	abstracts := r.PathPrefix("/abstracts/{id:[-a-f0-9]+}").Subrouter()
 	abstracts.Methods("GET").HandlerFunc(GetAbstractHandler)
+	abstracts.Methods("PATCH").HandlerFunc(PatchAbstractHandler)
 	abstracts.Methods("DELETE").HandlerFunc(DeleteAbstractHandler)
 
 	fs := http.FileServer(http.Dir("./public/"))
@@ -81,7 +194,5 @@ func main() {
 	r.PathPrefix("/fonts").Handler(fs)
 	r.PathPrefix("/img").Handler(fs)
 
-	http.Handle("/", r)
-
-	http.ListenAndServe(addrFlag, nil)
+	return r
 }
@@ -0,0 +1,76 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * main_test.go: helpers for running handlers against a MemStore
+ *
+ */
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setupMem points the app's globals at a fresh MemStore
+func setupMem(t *testing.T) *MemStore {
+	t.Helper()
+	ms := NewMemStore()
+	db = ms
+	privKey = []byte("test")
+	store = NewCQLStore(db, privKey)
+	sessCookie = "t"
+	search = newSearchIndex()
+	return ms
+}
+
+// loginAs returns a session cookie for email
+func loginAs(t *testing.T, email string) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := setSessionEmail(rec, httptest.NewRequest("GET", "/", nil), email); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessCookie {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie for '%s'", email)
+	return nil
+}
+
+// do sends a request as c, nil is logged out. PATCH gets If-Match: *
+// so tests that aren't about versions don't have to fetch the ETag.
+func do(t *testing.T, h http.Handler, c *http.Cookie, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, rd)
+	if c != nil {
+		req.AddCookie(c)
+	}
+	if method == "PATCH" {
+		req.Header.Set("If-Match", "*")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,743 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * memstore.go: an in-memory implementation of Store
+ *
+ * Everything is gone when the process exits. Good for hacking on the
+ * UI and for tests, not much else.
+ *
+ */
+
+import (
+	"github.com/gocql/gocql"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemStore struct {
+	mtx       sync.RWMutex
+	abstracts map[gocql.UUID]Abstract
+	scores    map[gocql.UUID]CriteriaScores
+	events    map[string]Event
+	rubrics   map[string]map[string]Criterion      // event -> name -> criterion
+	tracks    map[string]map[string]Track          // event -> id -> track
+	assigned  map[string]map[gocql.UUID]Assignment // email -> abstract -> assignment
+	conflicts map[gocql.UUID]map[string]Conflict   // abstract -> email -> conflict
+	comments  map[gocql.UUID]Comments
+	audits    map[gocql.UUID]ScoreAudits
+	revisions map[gocql.UUID]Revisions
+	admins    map[string]bool
+	reviewers map[string]Reviewer
+	speakers  map[Email]Speaker
+	sessions  map[string]SessionRecord
+	tokens    map[string]LoginToken
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		abstracts: make(map[gocql.UUID]Abstract),
+		scores:    make(map[gocql.UUID]CriteriaScores),
+		events:    make(map[string]Event),
+		rubrics:   make(map[string]map[string]Criterion),
+		tracks:    make(map[string]map[string]Track),
+		assigned:  make(map[string]map[gocql.UUID]Assignment),
+		conflicts: make(map[gocql.UUID]map[string]Conflict),
+		comments:  make(map[gocql.UUID]Comments),
+		audits:    make(map[gocql.UUID]ScoreAudits),
+		revisions: make(map[gocql.UUID]Revisions),
+		admins:    make(map[string]bool),
+		reviewers: make(map[string]Reviewer),
+		speakers:  make(map[Email]Speaker),
+		sessions:  make(map[string]SessionRecord),
+		tokens:    make(map[string]LoginToken),
+	}
+}
+
+func (ms *MemStore) ListAbstracts() (Abstracts, error) {
+	all, err := ms.listAbstracts()
+	return splitTrash(all, false), err
+}
+
+func (ms *MemStore) PageAbstracts(size int, state []byte) (Abstracts, []byte, error) {
+	alist, err := ms.ListAbstracts()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pageSorted(alist, size, state)
+}
+
+func (ms *MemStore) ListTrash() (Abstracts, error) {
+	all, err := ms.listAbstracts()
+	return splitTrash(all, true), err
+}
+
+func (ms *MemStore) listAbstracts() (Abstracts, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	alist := make(Abstracts, 0, len(ms.abstracts))
+	for id, a := range ms.abstracts {
+		a = a.copy()
+		a.setScores(ms.scores[id])
+		a.defaultStatus()
+		alist = append(alist, a)
+	}
+
+	// map order is random, keep the list stable for the UI
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Created.Before(alist[j].Created)
+	})
+
+	return alist, nil
+}
+
+func (ms *MemStore) FetchAbstract(id gocql.UUID) (Abstract, error) {
+	a, err := ms.fetchAbstract(id)
+	return inTrash(a, err, false)
+}
+
+func (ms *MemStore) FetchTrash(id gocql.UUID) (Abstract, error) {
+	a, err := ms.fetchAbstract(id)
+	return inTrash(a, err, true)
+}
+
+func (ms *MemStore) fetchAbstract(id gocql.UUID) (Abstract, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	a, ok := ms.abstracts[id]
+	if !ok {
+		return Abstract{}, ErrNotFound
+	}
+
+	a = a.copy()
+	a.setScores(ms.scores[id])
+	a.defaultStatus()
+
+	return a, nil
+}
+
+// same as the CQL INSERT: scores and status are left alone
+func (ms *MemStore) SaveAbstract(a *Abstract) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.putAbstract(a)
+	return nil
+}
+
+// same as the CQL UPDATE ... IF version = ?
+func (ms *MemStore) UpdateAbstract(a *Abstract, version int) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.abstracts[a.Id].Version != version {
+		return ErrStale
+	}
+
+	a.Version = version + 1
+	ms.putAbstract(a)
+	return nil
+}
+
+// callers must hold the lock
+func (ms *MemStore) putAbstract(a *Abstract) {
+	na := a.copy()
+	old := ms.abstracts[a.Id]
+	na.ScoresA, na.ScoresB, na.ScoresC = old.ScoresA, old.ScoresB, old.ScoresC
+	na.ScoresD, na.ScoresE, na.ScoresF = old.ScoresD, old.ScoresE, old.ScoresF
+	na.ScoresG, na.ScoresNames = old.ScoresG, old.ScoresNames
+	na.Scores = nil
+	na.Status, na.StatusBy, na.StatusChanged = old.Status, old.StatusBy, old.StatusChanged
+	na.DeletedBy, na.DeletedAt = old.DeletedBy, old.DeletedAt
+	na.Tags = old.Tags
+	ms.abstracts[a.Id] = na
+}
+
+func (ms *MemStore) PurgeAbstract(id gocql.UUID) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.abstracts, id)
+	delete(ms.scores, id)
+	delete(ms.comments, id)
+	delete(ms.revisions, id)
+	delete(ms.audits, id)
+	delete(ms.conflicts, id)
+	for _, byAbs := range ms.assigned {
+		delete(byAbs, id)
+	}
+	return nil
+}
+
+func (ms *MemStore) SaveDeleted(a *Abstract) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	old, ok := ms.abstracts[a.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	old.DeletedBy, old.DeletedAt = a.DeletedBy, a.DeletedAt
+	ms.abstracts[a.Id] = old
+
+	return nil
+}
+
+// same as the CQL UPDATE: creates the row if it doesn't exist
+func (ms *MemStore) SaveStatus(a *Abstract) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	old, ok := ms.abstracts[a.Id]
+	if !ok {
+		old = Abstract{Id: a.Id}
+	}
+	old.Status, old.StatusBy, old.StatusChanged = a.Status, a.StatusBy, a.StatusChanged
+	ms.abstracts[a.Id] = old
+
+	return nil
+}
+
+func (ms *MemStore) SaveScore(su *ScoreUpdate) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.scores[su.Id] == nil {
+		ms.scores[su.Id] = make(CriteriaScores)
+	}
+	ms.scores[su.Id].set(su.Slot, su.Email, su.Score)
+
+	return nil
+}
+
+// same as the CQL set update: creates the row if it doesn't exist
+func (ms *MemStore) AddTag(absId gocql.UUID, tag Tag) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	a := ms.abstracts[absId]
+	a.Id = absId
+	a.Tags = addTag(a.Tags, tag)
+	ms.abstracts[absId] = a
+
+	return nil
+}
+
+func (ms *MemStore) RemoveTag(absId gocql.UUID, tag Tag) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if a, ok := ms.abstracts[absId]; ok {
+		a.Tags = removeTag(a.Tags, tag)
+		ms.abstracts[absId] = a
+	}
+
+	return nil
+}
+
+// there's no index, the abstracts are all in memory anyway
+func (ms *MemStore) ListTagged(tag Tag) ([]gocql.UUID, error) {
+	tags, err := ms.ListTags()
+	return tags[tag], err
+}
+
+func (ms *MemStore) ListTags() (map[Tag][]gocql.UUID, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	tags := make(map[Tag][]gocql.UUID)
+	for id, a := range ms.abstracts {
+		for _, tag := range a.Tags {
+			tags[tag] = append(tags[tag], id)
+		}
+	}
+
+	return tags, nil
+}
+
+func (ms *MemStore) SaveScoreAudit(sa *ScoreAudit) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	na := *sa
+	na.Created = na.Id.Time()
+	ms.audits[sa.AbsId] = append(ms.audits[sa.AbsId], na)
+
+	return nil
+}
+
+func (ms *MemStore) ListScoreAudits(absId gocql.UUID) (ScoreAudits, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	alist := make(ScoreAudits, len(ms.audits[absId]))
+	copy(alist, ms.audits[absId])
+
+	return alist, nil
+}
+
+func (ms *MemStore) ListRevisions(absId gocql.UUID) (Revisions, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	revs := make(Revisions, len(ms.revisions[absId]))
+	copy(revs, ms.revisions[absId])
+	revs.sort()
+
+	return revs, nil
+}
+
+func (ms *MemStore) FetchRevision(absId, id gocql.UUID) (Revision, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	for _, rv := range ms.revisions[absId] {
+		if rv.Id == id {
+			return rv, nil
+		}
+	}
+
+	return Revision{}, ErrNotFound
+}
+
+func (ms *MemStore) SaveRevision(rv *Revision) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	nr := *rv
+	nr.Created = nr.Id.Time()
+	ms.revisions[rv.AbsId] = append(ms.revisions[rv.AbsId], nr)
+
+	return nil
+}
+
+func (ms *MemStore) FetchEvent(name string) (Event, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	e, ok := ms.events[name]
+	if !ok {
+		return e, ErrNotFound
+	}
+
+	return e, nil
+}
+
+func (ms *MemStore) SaveEvent(e *Event) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.events[e.Name] = *e
+	return nil
+}
+
+func (ms *MemStore) ListRubric(event string) (Rubric, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	rubric := make(Rubric, 0, len(ms.rubrics[event]))
+	for _, c := range ms.rubrics[event] {
+		rubric = append(rubric, c.copy())
+	}
+
+	return rubric, nil
+}
+
+func (ms *MemStore) SaveCriterion(c *Criterion) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.rubrics[c.Event] == nil {
+		ms.rubrics[c.Event] = make(map[string]Criterion)
+	}
+	ms.rubrics[c.Event][c.Name] = c.copy()
+
+	return nil
+}
+
+func (ms *MemStore) DeleteCriterion(event, name string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.rubrics[event], name)
+	return nil
+}
+
+func (ms *MemStore) ListTracks(event string) ([]Track, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	tracks := make([]Track, 0, len(ms.tracks[event]))
+	for _, t := range ms.tracks[event] {
+		tracks = append(tracks, t.copy())
+	}
+
+	return tracks, nil
+}
+
+func (ms *MemStore) SaveTrack(t *Track) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.tracks[t.Event] == nil {
+		ms.tracks[t.Event] = make(map[string]Track)
+	}
+	ms.tracks[t.Event][t.Id] = t.copy()
+
+	return nil
+}
+
+func (ms *MemStore) DeleteTrack(event, id string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.tracks[event], id)
+	return nil
+}
+
+func (ms *MemStore) ListAssignments() (Assignments, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	alist := make(Assignments, 0)
+	for _, byAbs := range ms.assigned {
+		for _, as := range byAbs {
+			alist = append(alist, as)
+		}
+	}
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Assigned.Before(alist[j].Assigned)
+	})
+
+	return alist, nil
+}
+
+func (ms *MemStore) ListReviewerAssignments(email string) (Assignments, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	alist := make(Assignments, 0, len(ms.assigned[email]))
+	for _, as := range ms.assigned[email] {
+		alist = append(alist, as)
+	}
+	sort.Slice(alist, func(i, j int) bool {
+		return alist[i].Assigned.Before(alist[j].Assigned)
+	})
+
+	return alist, nil
+}
+
+func (ms *MemStore) SaveAssignment(as *Assignment) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.assigned[as.Email] == nil {
+		ms.assigned[as.Email] = make(map[gocql.UUID]Assignment)
+	}
+	ms.assigned[as.Email][as.AbsId] = *as
+
+	return nil
+}
+
+func (ms *MemStore) DeleteAssignment(email string, absId gocql.UUID) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.assigned[email], absId)
+	return nil
+}
+
+func (ms *MemStore) ListConflicts() (Conflicts, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	clist := make(Conflicts, 0)
+	for _, byEmail := range ms.conflicts {
+		for _, c := range byEmail {
+			clist = append(clist, c)
+		}
+	}
+	sort.Slice(clist, func(i, j int) bool {
+		return clist[i].Created.Before(clist[j].Created)
+	})
+
+	return clist, nil
+}
+
+func (ms *MemStore) FetchConflict(absId gocql.UUID, email string) (Conflict, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	c, ok := ms.conflicts[absId][email]
+	if !ok {
+		return c, ErrNotFound
+	}
+
+	return c, nil
+}
+
+func (ms *MemStore) SaveConflict(c *Conflict) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	if ms.conflicts[c.AbsId] == nil {
+		ms.conflicts[c.AbsId] = make(map[string]Conflict)
+	}
+	ms.conflicts[c.AbsId][c.Email] = *c
+
+	return nil
+}
+
+func (ms *MemStore) DeleteConflict(absId gocql.UUID, email string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.conflicts[absId], email)
+	return nil
+}
+
+func (ms *MemStore) ListComments(absId gocql.UUID) (Comments, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	clist := make(Comments, len(ms.comments[absId]))
+	copy(clist, ms.comments[absId])
+
+	return clist, nil
+}
+
+func (ms *MemStore) FetchComment(absId, id gocql.UUID) (Comment, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	for _, c := range ms.comments[absId] {
+		if c.Id == id {
+			return c, nil
+		}
+	}
+
+	return Comment{}, ErrNotFound
+}
+
+func (ms *MemStore) SaveComment(c *Comment) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	nc := *c
+	nc.Created = nc.Id.Time()
+
+	// comments are clustered by their timeuuid, overwrite on match
+	clist := ms.comments[c.AbsId]
+	for i, old := range clist {
+		if old.Id == c.Id {
+			clist[i] = nc
+			return nil
+		}
+	}
+
+	clist = append(clist, nc)
+	sort.Slice(clist, func(i, j int) bool {
+		return clist[i].Created.Before(clist[j].Created)
+	})
+	ms.comments[c.AbsId] = clist
+
+	return nil
+}
+
+func (ms *MemStore) ListAdmins() (Admins, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	alist := make(Admins, 0, len(ms.admins))
+	for email := range ms.admins {
+		alist = append(alist, email)
+	}
+	sort.Strings(alist)
+
+	return alist, nil
+}
+
+func (ms *MemStore) SaveAdmin(email string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.admins[email] = true
+	return nil
+}
+
+func (ms *MemStore) DeleteAdmin(email string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.admins, email)
+	return nil
+}
+
+func (ms *MemStore) ListSpeakers() ([]Speaker, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	slist := make([]Speaker, 0, len(ms.speakers))
+	for _, s := range ms.speakers {
+		slist = append(slist, s.copy())
+	}
+	sort.Slice(slist, func(i, j int) bool {
+		return slist[i].Email < slist[j].Email
+	})
+
+	return slist, nil
+}
+
+func (ms *MemStore) FetchSpeaker(email Email) (Speaker, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	s, ok := ms.speakers[email]
+	if !ok {
+		return s, ErrNotFound
+	}
+
+	return s.copy(), nil
+}
+
+func (ms *MemStore) SaveSpeaker(s *Speaker) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.speakers[s.Email] = s.copy()
+	return nil
+}
+
+func (ms *MemStore) DeleteSpeaker(email Email) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.speakers, email)
+	return nil
+}
+
+func (ms *MemStore) ListReviewers() ([]Reviewer, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	rlist := make([]Reviewer, 0, len(ms.reviewers))
+	for _, r := range ms.reviewers {
+		rlist = append(rlist, r)
+	}
+	sort.Slice(rlist, func(i, j int) bool {
+		return rlist[i].Email < rlist[j].Email
+	})
+
+	return rlist, nil
+}
+
+func (ms *MemStore) FetchReviewer(email string) (Reviewer, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	r, ok := ms.reviewers[email]
+	if !ok {
+		return r, ErrNotFound
+	}
+
+	return r, nil
+}
+
+func (ms *MemStore) SaveReviewer(r *Reviewer) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	nr := *r
+	nr.Tracks = append([]string{}, r.Tracks...)
+	ms.reviewers[r.Email] = nr
+	return nil
+}
+
+func (ms *MemStore) DeleteReviewer(email string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.reviewers, email)
+	return nil
+}
+
+func (ms *MemStore) LoadSession(id string) (SessionRecord, error) {
+	ms.mtx.RLock()
+	defer ms.mtx.RUnlock()
+
+	sr, ok := ms.sessions[id]
+	if !ok {
+		return sr, ErrNotFound
+	}
+
+	return sr, nil
+}
+
+func (ms *MemStore) SaveSession(sr *SessionRecord, isNew bool) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	old, ok := ms.sessions[sr.Id]
+	if ok && !isNew {
+		old.Email = sr.Email
+		old.Modified = sr.Modified
+		ms.sessions[sr.Id] = old
+	} else {
+		ms.sessions[sr.Id] = *sr
+	}
+
+	return nil
+}
+
+func (ms *MemStore) DeleteSession(id string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	delete(ms.sessions, id)
+	return nil
+}
+
+func (ms *MemStore) SaveLoginToken(lt *LoginToken) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	// nothing expires tokens for us, drop the stale ones here
+	now := time.Now()
+	for id, old := range ms.tokens {
+		if now.After(old.Expires) {
+			delete(ms.tokens, id)
+		}
+	}
+
+	ms.tokens[lt.Id] = *lt
+	return nil
+}
+
+func (ms *MemStore) ConsumeLoginToken(id string) (LoginToken, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	lt, ok := ms.tokens[id]
+	if !ok {
+		return lt, ErrNotFound
+	}
+	delete(ms.tokens, id)
+
+	return lt, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * memstore_test.go: the MemStore behaves like the Cassandra tables
+ *
+ */
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestMemStoreAbstracts(t *testing.T) {
+	ms := NewMemStore()
+	id := gocql.TimeUUID()
+
+	// scores can arrive before the abstract, like a CQL upsert
+	if err := ms.SaveScore(&ScoreUpdate{Id: id, Slot: "scores_a", Email: "r1@x", Score: 3}); err != nil {
+		t.Fatal(err)
+	}
+	a := Abstract{Id: id, Title: "t", Body: "b", Created: time.Now(), Authors: Authors{"a@b": "A"}, Version: 1}
+	if err := ms.SaveAbstract(&a); err != nil {
+		t.Fatal(err)
+	}
+	ms.SaveScore(&ScoreUpdate{Id: id, Slot: "scores_a", Email: "r2@x", Score: 1})
+
+	got, err := ms.FetchAbstract(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "t" || len(got.ScoresA) != 2 || got.ScoresA["r1@x"] != 3 {
+		t.Errorf("FetchAbstract = %+v", got)
+	}
+	if _, err := ms.FetchAbstract(gocql.TimeUUID()); err != ErrNotFound {
+		t.Errorf("FetchAbstract of a missing id = %v, want ErrNotFound", err)
+	}
+
+	// the stored copy isn't shared with the caller
+	got.Authors["c@d"] = "C"
+	again, _ := ms.FetchAbstract(id)
+	if len(again.Authors) != 1 {
+		t.Errorf("FetchAbstract returned a shared map: %v", again.Authors)
+	}
+
+	upd := again
+	upd.Title = "t2"
+	if err := ms.UpdateAbstract(&upd, 1); err != nil {
+		t.Fatal(err)
+	}
+	if upd.Version != 2 {
+		t.Errorf("UpdateAbstract left the version at %d, want 2", upd.Version)
+	}
+	stale := again
+	if err := ms.UpdateAbstract(&stale, 1); err != ErrStale {
+		t.Errorf("UpdateAbstract at an old version = %v, want ErrStale", err)
+	}
+
+	alist, err := ms.ListAbstracts()
+	if err != nil || len(alist) != 1 || alist[0].Title != "t2" {
+		t.Errorf("ListAbstracts = %v %v", alist, err)
+	}
+}
+
+func TestMemStoreTrash(t *testing.T) {
+	ms := NewMemStore()
+	id := gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: id, Title: "t"})
+	ms.SaveComment(&Comment{AbsId: id, Id: gocql.TimeUUID(), Email: "r@x", Body: "c"})
+
+	ms.SaveDeleted(&Abstract{Id: id, DeletedBy: "adm@x", DeletedAt: time.Now()})
+	if _, err := ms.FetchAbstract(id); err != ErrNotFound {
+		t.Errorf("FetchAbstract of a trashed abstract = %v", err)
+	}
+	if alist, _ := ms.ListAbstracts(); len(alist) != 0 {
+		t.Errorf("ListAbstracts includes the trash: %v", alist)
+	}
+	if trash, _ := ms.ListTrash(); len(trash) != 1 || trash[0].DeletedBy != "adm@x" {
+		t.Errorf("ListTrash = %v", trash)
+	}
+
+	// zero values restore
+	ms.SaveDeleted(&Abstract{Id: id})
+	if _, err := ms.FetchAbstract(id); err != nil {
+		t.Errorf("FetchAbstract after restore = %v", err)
+	}
+
+	ms.PurgeAbstract(id)
+	if trash, _ := ms.ListTrash(); len(trash) != 0 {
+		t.Errorf("ListTrash after purge = %v", trash)
+	}
+	if clist, _ := ms.ListComments(id); len(clist) != 0 {
+		t.Errorf("ListComments after purge = %v", clist)
+	}
+}
+
+func TestMemStoreComments(t *testing.T) {
+	ms := NewMemStore()
+	absId := gocql.TimeUUID()
+	first := Comment{AbsId: absId, Id: gocql.TimeUUID(), Email: "r@x", Body: "1"}
+	second := Comment{AbsId: absId, Id: gocql.TimeUUID(), Email: "r@x", Body: "2"}
+
+	// comments are clustered by their timeuuid, not the order they're saved
+	ms.SaveComment(&second)
+	ms.SaveComment(&first)
+	first.Body = "1 edited"
+	ms.SaveComment(&first)
+
+	clist, err := ms.ListComments(absId)
+	if err != nil || len(clist) != 2 || clist[0].Body != "1 edited" || clist[1].Body != "2" {
+		t.Errorf("ListComments = %v %v", clist, err)
+	}
+	if c, err := ms.FetchComment(absId, second.Id); err != nil || c.Body != "2" {
+		t.Errorf("FetchComment = %v %v", c, err)
+	}
+	if _, err := ms.FetchComment(absId, gocql.TimeUUID()); err != ErrNotFound {
+		t.Errorf("FetchComment of a missing id = %v", err)
+	}
+}
+
+func TestMemStoreAdminsAndSessions(t *testing.T) {
+	ms := NewMemStore()
+	ms.SaveAdmin("a@x")
+	ms.SaveAdmin("b@x")
+	ms.DeleteAdmin("a@x")
+	if admins, _ := ms.ListAdmins(); len(admins) != 1 || admins[0] != "b@x" {
+		t.Errorf("ListAdmins = %v", admins)
+	}
+
+	sr := SessionRecord{Id: "s1", Email: "a@x", Created: time.Now()}
+	if err := ms.SaveSession(&sr, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ms.LoadSession("s1"); err != nil || got.Email != "a@x" {
+		t.Errorf("LoadSession = %v %v", got, err)
+	}
+	ms.DeleteSession("s1")
+	if _, err := ms.LoadSession("s1"); err != ErrNotFound {
+		t.Errorf("LoadSession after delete = %v", err)
+	}
+
+	ms.SaveLoginToken(&LoginToken{Id: "tok", Email: "a@x", Expires: time.Now().Add(time.Minute)})
+	if lt, err := ms.ConsumeLoginToken("tok"); err != nil || lt.Email != "a@x" {
+		t.Errorf("ConsumeLoginToken = %v %v", lt, err)
+	}
+	if _, err := ms.ConsumeLoginToken("tok"); err != ErrNotFound {
+		t.Errorf("ConsumeLoginToken twice = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * oidc.go: OpenID Connect authorization code login
+ *
+ * Mozilla Persona is gone, so this replaces it with a plain OIDC
+ * relying party: discovery, PKCE, and RS256 ID token verification.
+ * Only the standard library is used, same as the old persona code.
+ *
+ */
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/securecookie"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long the browser has to finish the round trip through the IdP
+const oidcPendingMaxAge = 600
+
+// tolerated clock difference between us and the IdP
+const oidcClockSkew = time.Minute
+
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Client       *http.Client
+
+	// filled in by discovery
+	AuthURL  string
+	TokenURL string
+	JWKSURL  string
+
+	mtx  sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type oidcDiscovery struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+type oidcTokenResp struct {
+	IdToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+// the aud claim can be a single string or a list
+type oidcAudience []string
+
+func (aud *oidcAudience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*aud = oidcAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*aud = many
+	return nil
+}
+
+type IDClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      oidcAudience `json:"aud"`
+	AuthorizedFor string       `json:"azp"`
+	Expires       int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified *bool        `json:"email_verified"`
+}
+
+// state kept in a signed cookie between /login and /login/callback
+type oidcPending struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewOIDCProvider fetches the issuer's discovery document and returns a
+// provider ready to use. client may be nil to use a default client.
+func NewOIDCProvider(issuer, clientId, clientSecret, redirectURL string, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Client:       client,
+	}
+
+	disc := oidcDiscovery{}
+	err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &disc)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %s", err)
+	}
+
+	if strings.TrimSuffix(disc.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer '%s', expected '%s'", disc.Issuer, p.Issuer)
+	}
+	if disc.AuthURL == "" || disc.TokenURL == "" || disc.JWKSURL == "" {
+		return nil, errors.New("OIDC discovery document is missing required endpoints")
+	}
+
+	p.AuthURL = disc.AuthURL
+	p.TokenURL = disc.TokenURL
+	p.JWKSURL = disc.JWKSURL
+
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthCodeURL returns the IdP URL to send the browser to
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.AuthURL + sep + params.Encode()
+}
+
+// Exchange trades an authorization code for tokens and returns the
+// verified claims from the ID token.
+func (p *OIDCProvider) Exchange(code, verifier, nonce string) (*IDClaims, error) {
+	params := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientID},
+	}
+
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// client_secret_basic, RFC 6749 2.3.1 wants these form encoded
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := oidcTokenResp{}
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		return nil, fmt.Errorf("token response is not valid JSON: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s %s", resp.Status, tr.Error, tr.ErrorDesc)
+	}
+	if tr.IdToken == "" {
+		return nil, errors.New("token response did not include an id_token")
+	}
+
+	return p.Verify(tr.IdToken, nonce)
+}
+
+// Verify checks the ID token's signature and claims.
+func (p *OIDCProvider) Verify(raw, nonce string) (*IDClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	hdr := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err := decodeSegment(parts[0], &hdr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token header: %s", err)
+	}
+
+	// never let the token pick something weaker like "none" or HS256
+	if hdr.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm '%s'", hdr.Alg)
+	}
+
+	key, err := p.key(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature encoding: %s", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, errors.New("ID token signature verification failed")
+	}
+
+	claims := IDClaims{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %s", err)
+	}
+
+	return &claims, p.checkClaims(&claims, nonce)
+}
+
+func (p *OIDCProvider) checkClaims(c *IDClaims, nonce string) error {
+	now := time.Now()
+
+	if strings.TrimSuffix(c.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("ID token issuer '%s' does not match '%s'", c.Issuer, p.Issuer)
+	}
+
+	found := false
+	for _, aud := range c.Audience {
+		if aud == p.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("ID token audience does not include this client")
+	}
+	if len(c.Audience) > 1 && c.AuthorizedFor != p.ClientID {
+		return errors.New("ID token azp does not match this client")
+	}
+
+	if c.Expires == 0 || now.After(time.Unix(c.Expires, 0).Add(oidcClockSkew)) {
+		return errors.New("ID token has expired")
+	}
+	if c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(oidcClockSkew)) {
+		return errors.New("ID token was issued in the future")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 {
+		return errors.New("ID token nonce does not match")
+	}
+
+	if c.Email == "" {
+		return errors.New("ID token does not contain an email, is the email scope allowed?")
+	}
+	if c.EmailVerified != nil && !*c.EmailVerified {
+		return errors.New("email address has not been verified by the identity provider")
+	}
+
+	return nil
+}
+
+// key returns the signing key with the given id, refreshing the
+// JWKS when the key isn't known yet (IdPs rotate keys)
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if k := p.lookupKey(kid); k != nil {
+		return k, nil
+	}
+
+	err := p.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", err)
+	}
+
+	if k := p.lookupKey(kid); k != nil {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("no signing key found for kid '%s'", kid)
+}
+
+// tokens without a kid are only acceptable when there's exactly one key
+func (p *OIDCProvider) lookupKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDCProvider) fetchKeys() error {
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+
+	err := p.getJSON(p.JWKSURL, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			log.Printf("oidc: skipping key '%s' with bad modulus: %s\n", k.Kid, err)
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			log.Printf("oidc: skipping key '%s' with bad exponent\n", k.Kid)
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.keys = keys
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// 32 random bytes, base64url encoded, used for state, nonce, and PKCE
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func oidcCookieName() string {
+	return sessCookie + "-oidc"
+}
+
+// LoginHandler sends the browser off to the IdP
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if idp == nil {
+		http.Error(w, "OpenID Connect login is not configured", http.StatusNotFound)
+		return
+	}
+
+	p := oidcPending{}
+	for _, s := range []*string{&p.State, &p.Nonce, &p.Verifier} {
+		var err error
+		*s, err = randomToken()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to generate login state: %s", err), 500)
+			return
+		}
+	}
+
+	blob, err := securecookie.EncodeMulti(oidcCookieName(), &p, store.Codecs...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode login state: %s", err), 500)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName(),
+		Value:    blob,
+		Path:     "/login",
+		MaxAge:   oidcPendingMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, idp.AuthCodeURL(p.State, p.Nonce, p.Verifier), http.StatusFound)
+}
+
+// LoginCallbackHandler is where the IdP sends the browser back to
+func LoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if idp == nil {
+		http.Error(w, "OpenID Connect login is not configured", http.StatusNotFound)
+		return
+	}
+
+	if e := r.FormValue("error"); e != "" {
+		log.Printf("LoginCallbackHandler: IdP returned error '%s': %s\n", e, r.FormValue("error_description"))
+		http.Error(w, fmt.Sprintf("Authentication failed: %s", e), http.StatusUnauthorized)
+		return
+	}
+
+	c, err := r.Cookie(oidcCookieName())
+	if err != nil {
+		http.Error(w, "login state cookie missing, please try again", http.StatusBadRequest)
+		return
+	}
+
+	// the pending state is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName(), Path: "/login", MaxAge: -1})
+
+	p := oidcPending{}
+	err = securecookie.DecodeMulti(oidcCookieName(), c.Value, &p, store.Codecs...)
+	if err != nil {
+		http.Error(w, "invalid login state cookie, please try again", http.StatusBadRequest)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(p.State)) != 1 {
+		http.Error(w, "login state mismatch, please try again", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := idp.Exchange(r.FormValue("code"), p.Verifier, p.Nonce)
+	if err != nil {
+		log.Printf("LoginCallbackHandler: %s\n", err)
+		http.Error(w, fmt.Sprintf("Authentication failed: %s", err), http.StatusUnauthorized)
+		return
+	}
+
+	err = setSessionEmail(w, r, claims.Email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to save session: %s", err), 500)
+		return
+	}
+
+	log.Printf("LoginCallbackHandler: '%s' logged in\n", claims.Email)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
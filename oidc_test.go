@@ -0,0 +1,261 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * oidc_test.go: the login flow against an IdP running in httptest
+ *
+ */
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP hands out ID tokens for whatever code it's given, the claims
+// can be changed to test what the relying party rejects
+type testIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey // published in the JWKS
+	signer    *rsa.PrivateKey // what tokens are actually signed with
+	nonce     string
+	challenge string
+	claims    map[string]interface{} // merged over the defaults, nil deletes
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := &testIdP{key: key, signer: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 ip.URL,
+			"authorization_endpoint": ip.URL + "/auth",
+			"token_endpoint":         ip.URL + "/token",
+			"jwks_uri":               ip.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(ip.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(ip.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "cid" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good" || base64.RawURLEncoding.EncodeToString(sum[:]) != ip.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": ip.token(t)})
+	})
+	ip.Server = httptest.NewServer(mux)
+	t.Cleanup(ip.Close)
+
+	return ip
+}
+
+func (ip *testIdP) token(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":            ip.URL,
+		"sub":            "1234",
+		"aud":            "cid",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          ip.nonce,
+		"email":          "rev@example.com",
+		"email_verified": true,
+	}
+	for k, v := range ip.claims {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ip.signer, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// startLogin runs /login and returns the pending state cookie and the
+// state parameter, the IdP learns the nonce and challenge like it would
+// from the browser's redirect
+func startLogin(t *testing.T, ip *testIdP) (*http.Cookie, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	LoginHandler(rec, httptest.NewRequest("GET", "/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("GET /login = %d %s", rec.Code, rec.Body.String())
+	}
+
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), ip.URL+"/auth?") {
+		t.Fatalf("GET /login redirected to '%s'", loc)
+	}
+	q := loc.Query()
+	if q.Get("client_id") != "cid" || q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid email" {
+		t.Errorf("authorization request = %v", q)
+	}
+	ip.nonce, ip.challenge = q.Get("nonce"), q.Get("code_challenge")
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcCookieName() {
+			return c, q.Get("state")
+		}
+	}
+	t.Fatal("GET /login didn't set the state cookie")
+	return nil, ""
+}
+
+func callback(pending *http.Cookie, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/login/callback?"+query, nil)
+	if pending != nil {
+		req.AddCookie(pending)
+	}
+	rec := httptest.NewRecorder()
+	LoginCallbackHandler(rec, req)
+	return rec
+}
+
+func setupOIDC(t *testing.T) *testIdP {
+	t.Helper()
+	setupMem(t)
+	ip := newTestIdP(t)
+	var err error
+	idp, err = NewOIDCProvider(ip.URL, "cid", "secret", "http://localhost/login/callback", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idp = nil })
+	return ip
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ip := setupOIDC(t)
+	db.SaveReviewer(&Reviewer{Email: "rev@example.com"})
+
+	pending, state := startLogin(t, ip)
+	rec := callback(pending, "code=good&state="+url.QueryEscape(state))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("callback = %d %s", rec.Code, rec.Body.String())
+	}
+
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessCookie {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("callback didn't set the session cookie")
+	}
+	who := do(t, newRouter(), session, "GET", "/whoami", "")
+	if !strings.Contains(who.Body.String(), `"rev@example.com"`) {
+		t.Errorf("/whoami after login = %s", who.Body.String())
+	}
+}
+
+func TestOIDCRejects(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		forge  bool   // sign with a key the JWKS doesn't have
+		query  string // instead of a good code and state
+		code   int
+	}{
+		{name: "wrong issuer", claims: map[string]interface{}{"iss": "https://evil.example.com"}, code: 401},
+		{name: "wrong audience", claims: map[string]interface{}{"aud": "someone-else"}, code: 401},
+		{name: "expired", claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, code: 401},
+		{name: "issued in the future", claims: map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}, code: 401},
+		{name: "wrong nonce", claims: map[string]interface{}{"nonce": "replayed"}, code: 401},
+		{name: "no email", claims: map[string]interface{}{"email": nil}, code: 401},
+		{name: "unverified email", claims: map[string]interface{}{"email_verified": false}, code: 401},
+		{name: "bad signature", forge: true, code: 401},
+		{name: "bad code", query: "code=bad", code: 401},
+		{name: "state mismatch", query: "code=good&state=nope", code: 400},
+		{name: "IdP error", query: "error=access_denied", code: 401},
+	}
+
+	for _, tc := range tests {
+		ip := setupOIDC(t)
+		ip.claims = tc.claims
+		if tc.forge {
+			ip.signer = other
+		}
+
+		pending, state := startLogin(t, ip)
+		query := tc.query
+		if query == "" {
+			query = "code=good&state=" + url.QueryEscape(state)
+		} else if !strings.Contains(query, "state=") {
+			query += "&state=" + url.QueryEscape(state)
+		}
+
+		rec := callback(pending, query)
+		if rec.Code != tc.code {
+			t.Errorf("%s: callback = %d, want %d: %s", tc.name, rec.Code, tc.code, rec.Body.String())
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == sessCookie {
+				t.Errorf("%s: callback set a session cookie", tc.name)
+			}
+		}
+	}
+}
+
+func TestOIDCMissingState(t *testing.T) {
+	setupOIDC(t)
+	if rec := callback(nil, "code=good&state=x"); rec.Code != http.StatusBadRequest {
+		t.Errorf("callback without the state cookie = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * patch.go: partial updates to abstracts with JSON Merge Patch (RFC 7386)
+ *
+ * Fields left out of the patch are left alone, null clears a field, and
+ * objects like authors are merged key by key so {"authors": {"a@b.c": null}}
+ * removes one author without touching the others. Scores and status have
+ * their own endpoints and can't be patched.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// the only fields a patch may change, id is allowed as long as it matches
+var patchableFields = map[string]bool{
+	"upstream_id": true,
+	"title":       true,
+	"body":        true,
+	"authors":     true,
+	"company":     true,
+	"jobtitle":    true,
+	"bio":         true,
+	"tracks":      true,
+	"track_ids":   true,
+}
+
+// mergePatch applies patch to target as described in RFC 7386
+func mergePatch(target, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = make(map[string]interface{})
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergePatch(tm[k], v)
+		}
+	}
+
+	return tm
+}
+
+// applyPatch returns a copy of old with the patch applied
+func (old *Abstract) applyPatch(patch map[string]interface{}) (Abstract, error) {
+	a := Abstract{}
+
+	for k, v := range patch {
+		if k == "id" {
+			if id, ok := v.(string); !ok || id != old.Id.String() {
+				return a, fmt.Errorf("id in the body doesn't match the abstract")
+			}
+			continue
+		}
+		if !patchableFields[k] {
+			return a, fmt.Errorf("field '%s' can't be patched", k)
+		}
+	}
+
+	js, err := json.Marshal(old)
+	if err != nil {
+		return a, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return a, err
+	}
+
+	js, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return a, err
+	}
+	err = json.Unmarshal(js, &a)
+	return a, err
+}
+
+// PATCH /abstracts/{id}, the id can also be in the body of a PATCH
+// to /abstracts/ for older clients
+func PatchAbstractHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermEdit)
+	if u == nil {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler failed to read the body: %s", err), 500)
+		return
+	}
+	patch := make(map[string]interface{})
+	err = json.Unmarshal(body, &patch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler invalid json data: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	idstr := mux.Vars(r)["id"]
+	if idstr == "" {
+		idstr, _ = patch["id"].(string)
+	}
+	id, err := gocql.ParseUUID(idstr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+
+	old, err := db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler failed: %s", err), 500)
+		return
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load event settings: %s", err), 500)
+		return
+	}
+
+	// track leads can only touch abstracts in their tracks, both before
+	// and after the edit
+	if !u.CanEdit(&old) {
+		http.Error(w, "abstract is not in your tracks", http.StatusForbidden)
+		return
+	}
+
+	// the edit has to be against the version the editor loaded
+	if !checkIfMatch(w, r, old.Version) {
+		return
+	}
+
+	a, err := old.applyPatch(patch)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler invalid patch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// during blind review the editor never saw who the speaker is,
+	// so whatever they sent for the identity fields isn't a change
+	if ev.hidesIdentity(u) {
+		a.keepIdentity(&old)
+	}
+
+	err = resolveTracks(&a, &old)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler invalid patch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if !u.CanEdit(&a) {
+		http.Error(w, "abstract is not in your tracks", http.StatusForbidden)
+		return
+	}
+
+	if a.Title == "" || a.Body == "" || len(a.Authors) == 0 {
+		http.Error(w, "a patch can't remove the title, body or authors", http.StatusBadRequest)
+		return
+	}
+
+	err = saveAbstract(&a, &old, u.Email)
+	if err == ErrStale {
+		http.Error(w, "the abstract was changed by somebody else, reload it and try again", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		log.Printf("PatchAbstractHandler saveAbstract() failed: %s\n", err)
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler saveAbstract() failed: %s", err), 500)
+		return
+	}
+
+	err = ensureSpeakers(&a)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler ensureSpeakers() failed: %s", err), 500)
+		return
+	}
+
+	// the saved abstract doesn't include scores, send back the full one
+	// the same way GetAbstractHandler would
+	a, err = db.FetchAbstract(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("PatchAbstractHandler failed: %s", err), 500)
+		return
+	}
+	if _, ok, err := checkConflict(u.Email, &a); err != nil {
+		http.Error(w, fmt.Sprintf("failed to check conflicts: %s", err), 500)
+		return
+	} else if ok {
+		a.hideScores()
+	}
+	if ev.hidesIdentity(u) {
+		a.hideIdentity()
+	}
+
+	setETag(w, a.Version)
+	jsonOut(w, r, a)
+}
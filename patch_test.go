@@ -0,0 +1,155 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * patch_test.go: JSON Merge Patch and PATCH /abstracts/
+ *
+ */
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+// the examples from RFC 7386 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tc := range tests {
+		var target, patch interface{}
+		json.Unmarshal([]byte(tc.target), &target)
+		json.Unmarshal([]byte(tc.patch), &patch)
+		got, err := json.Marshal(mergePatch(target, patch))
+		if err != nil || string(got) != tc.want {
+			t.Errorf("mergePatch(%s, %s) = %s %v, want %s", tc.target, tc.patch, got, err, tc.want)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	old := Abstract{
+		Id:      gocql.TimeUUID(),
+		Title:   "t",
+		Body:    "b",
+		Company: "c",
+		Authors: Authors{"a@z": "A", "b@z": "B"},
+		Scores:  CriteriaScores{"q": Scores{"r@x": 2}},
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		err   bool
+		check func(a Abstract) bool
+	}{
+		{"title", `{"title":"t2"}`, false, func(a Abstract) bool {
+			return a.Title == "t2" && a.Body == "b" && a.Company == "c"
+		}},
+		{"clear", `{"company":null}`, false, func(a Abstract) bool {
+			return a.Company == "" && a.Title == "t"
+		}},
+		{"merge authors", `{"authors":{"b@z":null,"c@z":"C"}}`, false, func(a Abstract) bool {
+			return len(a.Authors) == 2 && a.Authors["a@z"] == "A" && a.Authors["c@z"] == "C"
+		}},
+		{"keeps scores", `{"title":"t2"}`, false, func(a Abstract) bool {
+			return a.Scores["q"]["r@x"] == 2
+		}},
+		{"matching id", `{"id":"` + old.Id.String() + `","title":"t2"}`, false, func(a Abstract) bool {
+			return a.Id == old.Id
+		}},
+		{"other id", `{"id":"` + gocql.TimeUUID().String() + `"}`, true, nil},
+		{"scores", `{"scores":{}}`, true, nil},
+		{"status", `{"status":"accepted"}`, true, nil},
+		{"version", `{"version":9}`, true, nil},
+	}
+
+	for _, tc := range tests {
+		patch := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(tc.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		a, err := old.applyPatch(patch)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: applyPatch(%s) error = %v", tc.name, tc.patch, err)
+		} else if tc.check != nil && !tc.check(a) {
+			t.Errorf("%s: applyPatch(%s) = %+v", tc.name, tc.patch, a)
+		}
+	}
+
+	if len(old.Authors) != 2 || old.Authors["b@z"] != "B" || old.Title != "t" {
+		t.Errorf("applyPatch changed the original: %+v", old)
+	}
+}
+
+func TestPatchAbstractHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	id := gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: id, Title: "t", Body: "b", Authors: Authors{"a@z": "A"}, Version: 1})
+	h, adm := newRouter(), loginAs(t, "adm@x")
+	path := "/abstracts/" + id.String()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{"edit", path, `{"title":"t2"}`, 200},
+		{"old clients", "/abstracts/", `{"id":"` + id.String() + `","bio":"bio"}`, 200},
+		{"missing", "/abstracts/" + gocql.TimeUUID().String(), `{"title":"x"}`, 404},
+		{"not patchable", path, `{"scores":{}}`, 400},
+		{"remove the title", path, `{"title":null}`, 400},
+		{"remove the last author", path, `{"authors":{"a@z":null}}`, 400},
+		{"bad json", path, `{"title":`, 400},
+	}
+	for _, tc := range tests {
+		if rec := do(t, h, adm, "PATCH", tc.path, tc.body); rec.Code != tc.code {
+			t.Errorf("%s: PATCH %s = %d, want %d: %s", tc.name, tc.path, rec.Code, tc.code, rec.Body.String())
+		}
+	}
+
+	a, _ := ms.FetchAbstract(id)
+	if a.Title != "t2" || a.Bio != "bio" || a.Body != "b" || a.Version != 3 {
+		t.Errorf("abstract after the patches = %+v", a)
+	}
+
+	// a new author gets a profile the same way PUT makes one
+	if rec := do(t, h, adm, "PATCH", path, `{"authors":{"n@z":"New"}}`); rec.Code != 200 {
+		t.Fatalf("PATCH a new author = %d %s", rec.Code, rec.Body.String())
+	}
+	if s, err := ms.FetchSpeaker("n@z"); err != nil || s.Name != "New" {
+		t.Errorf("speaker for the new author = %+v %v", s, err)
+	}
+}
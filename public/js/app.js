@@ -23,19 +23,24 @@
  */
 var ccfp = ccfp || {};
 
-// populated from the server in ccfp.run()
-// this list is used to display admin functions to admins
+// populated from /whoami in login.js
+// these are used to display admin functions to admins
 // and should absolutely NEVER be used for security
 // any security verification belongs in the backend code
-ccfp.admins = [];
+ccfp.role = "";
+ccfp.perms = [];
 
 // only scores_a is being used at the moment, but most of the
 // support for b-g is still here (for future use?)
 ccfp.scores_fields = ["scores_a", "scores_b", "scores_c", "scores_d", "scores_e", "scores_f", "scores_g"];
 
+// defaults, loadRubric replaces these if the rubric has labels
 // don't change these (or all the data in the DB will no longer match)
 ccfp.scores_a_values = { "1": "No", "2": "Maybe", "3": "Yes" };
 
+// the criteria to score, loadRubric replaces this with the server's
+ccfp.rubric = [{ "name": "scores_a", "description": "My Choice", "labels": ccfp.scores_a_values }];
+
 // needs to match the table structure in index.html
 ccfp.table_fields = [ "authors", "title", "company", "scores_a", "rate-link" ];
 
@@ -59,11 +64,11 @@ ccfp.admin_fields = [
 ccfp.csv_fields = [
   "id", "upstream_id", "names", "emails", "title", "body", "company", "reviews",
   "scores_a-count", "scores_a-yes", "scores_a-maybe", "scores_a-no",
-  "jobtitle", "bio", "tracks"
+  "jobtitle", "bio", "tracks", "status"
 ];
 
 // TODO: figure out what this was supposed to do.
-// persona.js has this after logout, so I think it's supposed to
+// login.js has this after logout, so I think it's supposed to
 // make sure any controls like edit buttons are disabled.
 ccfp.disable = function () {
 	return true;
@@ -105,7 +110,7 @@ ccfp.computeStats = function (data) {
 
     // copy over most fields as-is
 		// required fields
-    ["id", "upstream_id", "title", "body"].forEach(function (f) {
+    ["id", "upstream_id", "title", "body", "status"].forEach(function (f) {
       curr[f] = a[f];
     });
     // possibly not available
@@ -400,46 +405,54 @@ ccfp.createScoringModals = function (data) {
       .attr("rows", 8).classed({"form-control": true, "ccfp-textarea": true})
       .text(a["body"]);
 
+    // filled in by populateSpeakers when the modal is shown
+    b.append("div").attr("id", "speakers-" + id);
+
     b.append("hr");
 
-    var choice = 0;
+    // one row of buttons per rubric criterion
+    ccfp.rubric.forEach(function (c) {
+      var slot = c["name"];
+      var values = c["labels"];
+      if (_.isEmpty(values)) {
+        values = {};
+        for (var v = c["min"]; v <= c["max"]; v++) {
+          values["" + v] = "" + v;
+        }
+      }
 
-    if (a["scores_a"] == null) {
-      a["scores_a"] = {};
-    }
+      var scores = (a["scores"] || {})[slot] || a[slot] || {};
+      var choice = scores.hasOwnProperty(userEmail) ? "" + scores[userEmail] : null;
 
-    if (a["scores_a"].hasOwnProperty(userEmail)) {
-      choice = a["scores_a"][userEmail];
-    }
+      var r = b.append("div").classed({ "row": true, "ccfp-view": true });
+      r.append("div").classed("col-sm-3", true)
+        .append("strong")
+        .html(c["description"] || slot);
+
+      var rdiv = r.append("div")
+        .classed({"col-sm-9": true, "btn-group": true})
+        .attr("data-toggle", "buttons");
 
-    var r = b.append("div").classed({ "row": true, "ccfp-view": true });
-    r.append("div").classed("col-sm-3", true)
-      .append("strong")
-      .html("My Choice");
-
-		var rdiv = r.append("div")
-		 .classed({"col-sm-9": true, "btn-group": true})
-		 .attr("data-toggle", "buttons");
-
-		rdiv.selectAll("label")
-		 .data(_.keys(ccfp.scores_a_values))
-		 .enter()
-		   .append("label")
-		     .classed({"btn": true, "btn-primary": true})
-		     .html(function (d) { return ccfp.scores_a_values[d]; })
-         .classed("active", function (d) { return choice === d; })
-		     .on("click", function (d) {
-					 ccfp.updateScores(id, "scores_a", d);
-				 })
-		   .append("input")
-				 .attr("id", "scores_a-" + id)
-         .attr("type", "radio")
-         .attr("name", "scores_a-" + id)
-         .attr("autocomplete", "off") // recommended by bootstrap docs
-         .attr("checked", function (d) {
-					 if (choice === d) { return "1" }
-					 else { return null };
-				 });
+      rdiv.selectAll("label")
+        .data(_.keys(values))
+        .enter()
+          .append("label")
+            .classed({"btn": true, "btn-primary": true})
+            .html(function (d) { return values[d]; })
+            .classed("active", function (d) { return choice === d; })
+            .on("click", function (d) {
+              ccfp.updateScores(id, slot, d);
+            })
+          .append("input")
+            .attr("id", slot + "-" + id)
+            .attr("type", "radio")
+            .attr("name", slot + "-" + id)
+            .attr("autocomplete", "off") // recommended by bootstrap docs
+            .attr("checked", function (d) {
+              if (choice === d) { return "1" }
+              else { return null };
+            });
+    });
 
     b.append("hr");
 
@@ -579,6 +592,45 @@ ccfp.populateComments = function (id) {
     });
 };
 
+// speaker profiles only come with the single abstract, they're empty
+// during blind review
+ccfp.populateSpeakers = function (id) {
+  $.ajax({ url: "/abstracts/" + id, type: "GET", dataType: "json" })
+    .done(function (data, status, xhr) {
+      var speakers = d3.select("#speakers-" + id);
+      speakers.selectAll("*").remove(); // clear
+
+      var srow = function (key) {
+        var r = speakers.append("div").classed({ "row": true, "ccfp-view": true });
+        r.append("div").classed("col-sm-3", true).append("strong").text(key);
+        return r.append("div").classed("col-sm-9", true);
+      };
+
+      // with one speaker the abstract's bio is theirs
+      if (data["speakers"] != null && data["speakers"].length > 1) {
+        data["speakers"].forEach(function (sp) {
+          srow(sp["name"]).text([sp["jobtitle"], sp["company"]].filter(Boolean).join(", "));
+          speakers.append("div").classed("row", true)
+            .append("div").classed({ "col-sm-12": true, "ccfp-view": true })
+            .append("textarea").classed({"form-control": true, "ccfp-textarea": true})
+            .attr("disabled", true)
+            .attr("rows", 3)
+            .text(sp["bio"]);
+        });
+      }
+
+      if (data["other_submissions"] != null && data["other_submissions"].length > 0) {
+        var list = srow("Other Submissions").append("ul").classed("list-unstyled", true);
+        data["other_submissions"].forEach(function (o) {
+          list.append("li").text(o["title"] + " (" + o["status"] + ")");
+        });
+      }
+    })
+    .fail(function (data, status, xhr) {
+      console.log("XHR failed.", data, status, xhr);
+    });
+};
+
 ccfp.newAbstractForm = function () {
   $('#abstract-form')[0].reset();
   $("#form-abstract-id").val("");
@@ -591,6 +643,8 @@ ccfp.setupEditForm = function (id) {
 
   $.ajax({ url: "/abstracts/" + id, dataType: "json" })
     .done(function (data, status, xhr) {
+      // sent back with the edit so it fails if somebody else saved first
+      ccfp.formETag = xhr.getResponseHeader("ETag");
       $("#form-abstract-id").val(data["id"]);
       $('#abstract-form-modal-title').html("Editing Abtract: " + data["title"]);
       $("#body").val(data["body"]);
@@ -616,6 +670,9 @@ ccfp.setupEditForm = function (id) {
       $("#author0").val(authors.join(", "));
       $("#email0").val(emails.join(", "));
 
+      // remembered so the save can tell the server which authors went away
+      ccfp.formAuthors = data["authors"];
+
       ["company", "jobtitle", "bio", "tracks"].forEach(function (key) {
           $("#" + key).val(data[key]);
       });
@@ -653,7 +710,7 @@ ccfp.confirmDeleteAbstract = function (id) {
 			.html("&times;");
 	header.append("h4")
 		.classed("modal-title", true)
-		.text("Delete Abstract " + id + "?");
+		.text("Move Abstract " + id + " to the trash?");
 
 	var footer = modal.append("div").classed("modal-footer", true);
 	footer.append("button")
@@ -666,7 +723,7 @@ ccfp.confirmDeleteAbstract = function (id) {
 		.text("DELETE")
 		.on("click", function () {
 			console.log("Deleting ID: ", id);
-  		$.ajax({ url: "/abstracts/" + id, type: "DELETE", dataType: "json" });
+			$.ajax({ url: "/abstracts/" + id, type: "DELETE", dataType: "json" });
 			// TODO: handle errors?
 
 			$("#delete-modal").modal("toggle");
@@ -692,23 +749,37 @@ ccfp.saveAbstractForm = function () {
   };
   abs["authors"][$("#email0").val()] = $("#author0").val();
 
-  // if the ID is set, that means this is an edit so pass it to the
-  // server, otherwise the field must not exist in the JSON or parsing
-  // will fail since "" is an invalid uuid
+  // if the ID is set, that means this is an edit so it goes in the
+  // URL, otherwise it's a new abstract and the server picks the id
   var method = "PUT";
+  var url = "/abstracts/";
+  var headers = {};
   var id = $("#form-abstract-id").val();
   if (id.length == 36) {
-    abs["id"] = id;
     method = "PATCH";
+    url = "/abstracts/" + id;
+    headers["If-Match"] = ccfp.formETag;
+
+    // PATCH merges authors, so ones that were replaced have to be
+    // removed explicitly with null
+    for (var email in ccfp.formAuthors) {
+      if (ccfp.formAuthors.hasOwnProperty(email) && !abs["authors"].hasOwnProperty(email)) {
+        abs["authors"][email] = null;
+      }
+    }
   }
 
-  $.ajax({ url: "/abstracts/", type: method, data: JSON.stringify(abs), dataType: "json" })
+  $.ajax({ url: url, type: method, headers: headers, data: JSON.stringify(abs), dataType: "json" })
     .done(function (data, status, xhr) {
       ccfp.deleteOverview();
       ccfp.renderOverview();
       console.log("Saved to backend.", data, status, xhr);
     })
     .fail(function (data, status, xhr) {
+      if (data.status == 412) {
+        alert("Somebody else saved this abstract while you were editing it. Reload it and make your changes again.");
+        return;
+      }
       alert("XHR failed: please email info@planetcassandra.org");
       console.log("XHR save of abstract form failed.", data, status, xhr);
     });
@@ -741,55 +812,77 @@ ccfp.enableAdminLinks = function () {
     });
 };
 
+// anybody who can edit abstracts gets the admin columns and links
 ccfp.isAdmin = function () {
-	  return _.contains(ccfp.admins, userEmail);
+	  return ccfp.can("edit");
 };
 
-// persona can take a second or two to do its round trip with the
-// server, so rather than doing setup with $(document).ready, put
-// that code in run() and let the persona setup call it.
-ccfp.run = function () {
-  $.ajax({ url: '/admins/', dataType: "json" })
-    .done(function (data, status, xhr) {
-      ccfp.admins = data;
+ccfp.can = function (perm) {
+	  return _.contains(ccfp.perms, perm);
+};
 
-      if (ccfp.isAdmin()) {
-        ccfp.enableAdminLinks();
-      }
+// loads the criteria to score and replaces the default scores_a
+// labels with the ones from the rubric, if it has them
+ccfp.loadRubric = function (cb) {
+  $.ajax({ url: '/rubric/', dataType: "json" })
+    .done(function (data, status, xhr) {
+      ccfp.rubric = data;
+      data.forEach(function (c) {
+        if (c["name"] == "scores_a" && !_.isEmpty(c["labels"])) {
+          ccfp.scores_a_values = c["labels"];
+        }
+      });
     })
-    .fail(function (xhr, status, err) {
-      alert("/admins/ XHR failed: please email info@planetcassandra.org");
-      console.log("XHR failed: " + status);
-    });
+    .always(cb);
+};
 
+// login.js has to ask the server who is logged in before anything
+// else can happen, so rather than doing setup with $(document).ready, put
+// that code in run() and let the login setup call it.
+ccfp.run = function () {
+  if (!ccfp.can("read")) {
+    d3.select("#loading-animation").remove();
+    d3.select("#overview-panel").append("p")
+      .text("You are logged in as " + userEmail + " but have not been given a role yet. Ask a CFP admin for access.");
+    return;
+  }
 
-  // create a modal for each entry for entering scores
-  $.ajax({ url: '/abstracts/', dataType: "json" })
-    .done(function (data, status, xhr) {
-      var modals = ccfp.createScoringModals(data);
-
-			// add a listener to update comments on display of the modal
-			// this makes the page load more quickly and should handle concurrent
-			// users adding comments a little more cleanly without having to get fancy
-			// keys are uuids, values are dom ids
-			_.keys(modals).forEach(function (id) {
-				$("#" + modals[id]).on("shown.bs.modal", function () {
-					// load comments
-					ccfp.populateComments(id);
-					// make sure comments are enabled when a modal is displayed since a save
-					// ajax call may not have gotten a chance to reenable them
-					$("#new-comment-save-" + id).prop("disabled", false);
-					$("#new-comment-body-" + id).prop("disabled", false);
+  if (ccfp.isAdmin()) {
+    ccfp.enableAdminLinks();
+  }
+
+
+  // the rubric decides the labels for scores_a, so load it first
+  ccfp.loadRubric(function () {
+    // create a modal for each entry for entering scores
+    $.ajax({ url: '/abstracts/', dataType: "json" })
+      .done(function (data, status, xhr) {
+        var modals = ccfp.createScoringModals(data);
+
+				// add a listener to update comments on display of the modal
+				// this makes the page load more quickly and should handle concurrent
+				// users adding comments a little more cleanly without having to get fancy
+				// keys are uuids, values are dom ids
+				_.keys(modals).forEach(function (id) {
+					$("#" + modals[id]).on("shown.bs.modal", function () {
+						// load comments and speakers
+						ccfp.populateComments(id);
+						ccfp.populateSpeakers(id);
+						// make sure comments are enabled when a modal is displayed since a save
+						// ajax call may not have gotten a chance to reenable them
+						$("#new-comment-save-" + id).prop("disabled", false);
+						$("#new-comment-body-" + id).prop("disabled", false);
+					});
 				});
-			});
 
-      // render the overview after the modals are ready
-      ccfp.renderOverview();
-    })
-    .fail(function (xhr, status, err) {
-      alert("/abstracts/ XHR failed: please email info@planetcassandra.org");
-      console.log("XHR failed: " + status);
-    });
+        // render the overview after the modals are ready
+        ccfp.renderOverview();
+      })
+      .fail(function (xhr, status, err) {
+        alert("/abstracts/ XHR failed: please email info@planetcassandra.org");
+        console.log("XHR failed: " + status);
+      });
+  });
 
   $('#abstract-form-submit').on('click', function (e) {
     $("#abstract-form").validate({
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2016 Albert P. Tobey <tobert@gmail.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * requires: jQuery and underscore.js
+ *
+ * Login is either a full-page redirect through the OpenID Connect provider
+ * or a link sent by email, so all this has to do is ask the server who we
+ * are on page load.
+ */
+
+var userEmail = "";
+var authDone = false;
+var loginMethods = [];
+
+$( document ).ready(function() {
+  function loggedIn(email) {
+    userEmail = email;
+    $('#login').removeClass('active');
+    $('#login-email').removeClass('active');
+    $('#logout').addClass('active');
+    $('#action-menu').addClass('active');
+    $('#username').html("<span>" + email + "</span>");
+  }
+
+  function loggedOut() {
+    userEmail = "";
+    $('#logout').removeClass('active');
+    $('#action-menu').removeClass('active');
+    $('#username').html(" ");
+    if (_.contains(loginMethods, "oidc")) {
+      $('#login').addClass('active');
+    }
+    if (_.contains(loginMethods, "email")) {
+      $('#login-email').addClass('active');
+    }
+  }
+
+  function emailLogin() {
+    var email = window.prompt("Enter your email address and we'll send you a login link.");
+    if (email == null || email == "") {
+      return;
+    }
+    $.ajax({ url: '/login/email', type: 'POST', data: { email: email }, dataType: "json" })
+      .done(function(data, status, xhr) {
+        alert("If " + email + " is on the reviewer list, a login link is on its way.");
+      }).fail(function(xhr, status, err) {
+        alert("Sending the login link failed: " + err);
+      });
+  }
+
+  $.ajax({ url: '/whoami', dataType: "json" })
+    .done(function(data, status, xhr) {
+      loginMethods = data["logins"] || [];
+      ccfp.role = data["role"];
+      ccfp.perms = data["perms"] || [];
+      if (data["email"] != "") {
+        loggedIn(data["email"]);
+        ccfp.run();
+      } else {
+        loggedOut();
+        ccfp.disable();
+      }
+    }).fail(function(xhr, status, err) {
+      console.log("whoami failure: " + err);
+      loggedOut();
+      ccfp.disable();
+    });
+
+  $('#login').on('click', function (e) {
+    window.location.href = '/login';
+  });
+
+  $('#login-email').on('click', function (e) {
+    emailLogin();
+  });
+
+  $('#logout').on('click', function (e) {
+    console.log("Logging out.");
+    $.ajax({
+      type: 'POST',
+      dataType: "json",
+      url: '/logout'
+    }).always(function() {
+      loggedOut();
+      ccfp.disable();
+      window.location.href = '/';
+    });
+  });
+});
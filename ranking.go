@@ -0,0 +1,331 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * ranking.go: aggregate scores and rank the abstracts
+ *
+ * Each criterion's mean is scaled to 0-1 using the rubric's min/max so
+ * a 1-3 criterion and a 1-10 criterion can be combined, then the
+ * weighted total is the weighted average of those. Criteria nobody has
+ * scored yet are left out rather than counted as zero. Scores from
+ * reviewers with a conflict of interest are never counted.
+ *
+ * Reviewers don't all use the scale the same way, so scores can be
+ * normalized per reviewer and criterion before they're aggregated:
+ *
+ *   raw         the scores as entered (default)
+ *   zscore      (score - reviewer's mean) / reviewer's stddev
+ *   percentile  where the score falls among everything the reviewer
+ *               gave on that criterion, 0-1, ties share the midpoint
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"math"
+	"net/http"
+	"sort"
+)
+
+type Normalization string
+
+const (
+	NormRaw        Normalization = "raw"
+	NormZScore     Normalization = "zscore"
+	NormPercentile Normalization = "percentile"
+)
+
+func (n Normalization) Valid() bool {
+	switch n {
+	case NormRaw, NormZScore, NormPercentile:
+		return true
+	}
+	return false
+}
+
+type CriterionStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+}
+
+type AbstractRank struct {
+	Rank     int                       `json:"rank"`
+	Id       gocql.UUID                `json:"id"`
+	Title    string                    `json:"title"`
+	Tracks   string                    `json:"tracks"`
+	Reviews  int                       `json:"reviews"`  // reviewers who scored at least one criterion
+	Weighted float64                   `json:"weighted"` // 0-1 for raw and percentile
+	Criteria map[string]CriterionStats `json:"criteria"`
+}
+
+type Ranking []AbstractRank
+
+func (s Scores) values() []float64 {
+	values := make([]float64, 0, len(s))
+	for _, score := range s {
+		values = append(values, float64(score))
+	}
+	return values
+}
+
+// computeStats sorts values in place
+func computeStats(values []float64) CriterionStats {
+	cs := CriterionStats{Count: len(values)}
+	if cs.Count == 0 {
+		return cs
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	sort.Float64s(values)
+
+	cs.Mean = sum / float64(cs.Count)
+
+	mid := cs.Count / 2
+	if cs.Count%2 == 0 {
+		cs.Median = (values[mid-1] + values[mid]) / 2
+	} else {
+		cs.Median = values[mid]
+	}
+
+	// population stddev, a single review has no spread
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - cs.Mean) * (v - cs.Mean)
+	}
+	cs.StdDev = math.Sqrt(variance / float64(cs.Count))
+
+	return cs
+}
+
+// normalizeScores returns copies of the abstracts with each rubric
+// criterion's scores normalized across everything that reviewer scored
+func normalizeScores(alist Abstracts, rubric Rubric, norm Normalization) Abstracts {
+	if norm == NormRaw {
+		return alist
+	}
+
+	out := make(Abstracts, len(alist))
+	for i, a := range alist {
+		out[i] = a
+		out[i].Scores = make(CriteriaScores)
+	}
+
+	for _, c := range rubric {
+		// everything each reviewer gave on this criterion
+		given := make(map[Email][]float64)
+		for _, a := range alist {
+			for email, score := range a.Scores[c.Name] {
+				given[email] = append(given[email], float64(score))
+			}
+		}
+
+		// computeStats sorts, which percentile needs too
+		stats := make(map[Email]CriterionStats, len(given))
+		for email, values := range given {
+			stats[email] = computeStats(values)
+		}
+
+		for i, a := range alist {
+			for email, score := range a.Scores[c.Name] {
+				var ns float64
+				if norm == NormZScore {
+					ns = zscore(float64(score), stats[email])
+				} else {
+					ns = percentile(float64(score), given[email])
+				}
+				out[i].Scores.set(c.Name, email, Score(ns))
+			}
+		}
+	}
+
+	return out
+}
+
+// a reviewer who gives everything the same score has no opinion
+// relative to their other scores, call it average
+func zscore(score float64, stats CriterionStats) float64 {
+	if stats.StdDev == 0 {
+		return 0
+	}
+	return (score - stats.Mean) / stats.StdDev
+}
+
+// values must be sorted
+func percentile(score float64, values []float64) float64 {
+	below := sort.SearchFloat64s(values, score)
+	equal := 0
+	for i := below; i < len(values) && values[i] == score; i++ {
+		equal++
+	}
+	return (float64(below) + float64(equal)/2) / float64(len(values))
+}
+
+// scales a mean to 0-1 using the criterion's range, normalized
+// scores are already on a common scale and are left alone
+func (c *Criterion) scale(mean float64, norm Normalization) float64 {
+	if norm != NormRaw {
+		return mean
+	}
+
+	span := float64(c.Max - c.Min)
+	if span <= 0 {
+		return 1
+	}
+	return (mean - float64(c.Min)) / span
+}
+
+// rankAbstract aggregates one abstract's scores, only criteria in the
+// rubric are counted
+func rankAbstract(a *Abstract, rubric Rubric, norm Normalization) AbstractRank {
+	ar := AbstractRank{
+		Id:       a.Id,
+		Title:    a.Title,
+		Tracks:   a.Tracks,
+		Criteria: make(map[string]CriterionStats),
+	}
+
+	reviewers := make(map[Email]bool)
+	total, weights := 0.0, 0.0
+	for _, c := range rubric {
+		scores := a.Scores[c.Name]
+		if len(scores) == 0 {
+			continue
+		}
+		for email := range scores {
+			reviewers[email] = true
+		}
+
+		stats := computeStats(scores.values())
+		ar.Criteria[c.Name] = stats
+		total += c.Weight * c.scale(stats.Mean, norm)
+		weights += c.Weight
+	}
+
+	ar.Reviews = len(reviewers)
+	if weights > 0 {
+		ar.Weighted = total / weights
+	} else if len(ar.Criteria) > 0 {
+		// only weightless criteria were scored, e.g. just the overall
+		// call in the default rubric, so they count equally
+		for _, c := range rubric {
+			if stats, ok := ar.Criteria[c.Name]; ok {
+				ar.Weighted += c.scale(stats.Mean, norm)
+			}
+		}
+		ar.Weighted /= float64(len(ar.Criteria))
+	}
+
+	return ar
+}
+
+// rankAbstracts sorts by weighted total, then number of reviews so an
+// abstract with more agreement beats one lucky review, then title
+func rankAbstracts(alist Abstracts, rubric Rubric, norm Normalization) Ranking {
+	alist = normalizeScores(alist, rubric, norm)
+
+	ranking := make(Ranking, 0, len(alist))
+	for i := range alist {
+		ranking = append(ranking, rankAbstract(&alist[i], rubric, norm))
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].Weighted != ranking[j].Weighted {
+			return ranking[i].Weighted > ranking[j].Weighted
+		}
+		if ranking[i].Reviews != ranking[j].Reviews {
+			return ranking[i].Reviews > ranking[j].Reviews
+		}
+		return ranking[i].Title < ranking[j].Title
+	})
+
+	for i := range ranking {
+		ranking[i].Rank = i + 1
+	}
+
+	return ranking
+}
+
+// GET returns every abstract ranked by weighted score
+// ?normalize=zscore or percentile ranks on normalized scores instead
+func RankingHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	norm := Normalization(r.FormValue("normalize"))
+	if norm == "" {
+		norm = NormRaw
+	} else if !norm.Valid() {
+		http.Error(w, fmt.Sprintf("invalid normalize '%s', must be raw, zscore, or percentile", norm), http.StatusBadRequest)
+		return
+	}
+
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RankingHandler failed to load the rubric: %s", err), 500)
+		return
+	}
+
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RankingHandler failed to list abstracts: %s", err), 500)
+		return
+	}
+
+	ci, err := loadConflictIndex()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RankingHandler failed to load conflicts: %s", err), 500)
+		return
+	}
+	recused := make(map[gocql.UUID]bool)
+	for i := range alist {
+		if _, ok := ci.check(u.Email, &alist[i]); ok {
+			recused[alist[i].Id] = true
+		}
+	}
+	alist = dropConflicted(alist, ci)
+
+	jsonOut(w, r, rankAbstracts(alist, rubric, norm).hide(recused))
+}
+
+// hide blanks the scores of the abstracts in recused and moves them to
+// the end, the rest are renumbered so the gap doesn't give away where
+// the hidden ones placed
+func (ranking Ranking) hide(recused map[gocql.UUID]bool) Ranking {
+	out := make(Ranking, 0, len(ranking))
+	hidden := make(Ranking, 0)
+	for _, ar := range ranking {
+		if recused[ar.Id] {
+			hidden = append(hidden, AbstractRank{Id: ar.Id, Title: ar.Title, Tracks: ar.Tracks, Criteria: map[string]CriterionStats{}})
+			continue
+		}
+		ar.Rank = len(out) + 1
+		out = append(out, ar)
+	}
+	return append(out, hidden...)
+}
@@ -0,0 +1,274 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * ranking_test.go: score aggregation and ranking
+ *
+ */
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func scored(title string, scores CriteriaScores) Abstract {
+	return Abstract{Id: gocql.TimeUUID(), Title: title, Scores: scores}
+}
+
+func TestComputeStats(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   CriterionStats
+	}{
+		{[]float64{}, CriterionStats{}},
+		{[]float64{3}, CriterionStats{Count: 1, Mean: 3, Median: 3, StdDev: 0}},
+		{[]float64{3, 1, 2}, CriterionStats{Count: 3, Mean: 2, Median: 2, StdDev: math.Sqrt(2.0 / 3)}},
+		{[]float64{4, 1, 3, 2}, CriterionStats{Count: 4, Mean: 2.5, Median: 2.5, StdDev: math.Sqrt(1.25)}},
+		{[]float64{5, 3}, CriterionStats{Count: 2, Mean: 4, Median: 4, StdDev: 1}},
+		{[]float64{2, 2, 2, 9}, CriterionStats{Count: 4, Mean: 3.75, Median: 2, StdDev: math.Sqrt(9.1875)}},
+	}
+
+	for _, tc := range tests {
+		got := computeStats(append([]float64{}, tc.values...))
+		if got.Count != tc.want.Count || !near(got.Mean, tc.want.Mean) || !near(got.Median, tc.want.Median) || !near(got.StdDev, tc.want.StdDev) {
+			t.Errorf("computeStats(%v) = %+v, want %+v", tc.values, got, tc.want)
+		}
+	}
+}
+
+func TestRankAbstract(t *testing.T) {
+	rubric := Rubric{
+		{Name: "quality", Min: 1, Max: 5, Weight: 3},
+		{Name: "fit", Min: 1, Max: 3, Weight: 1},
+		{Name: "unscored", Min: 1, Max: 3, Weight: 10},
+	}
+	a := scored("a", CriteriaScores{
+		"quality": Scores{"r1": 5, "r2": 3},
+		"fit":     Scores{"r1": 1},
+		"retired": Scores{"r3": 99}, // not in the rubric
+	})
+
+	ar := rankAbstract(&a, rubric, NormRaw)
+	if ar.Reviews != 2 {
+		t.Errorf("Reviews = %d, want 2, r3 only scored a retired criterion", ar.Reviews)
+	}
+	if _, ok := ar.Criteria["retired"]; ok {
+		t.Error("a criterion that isn't in the rubric was counted")
+	}
+	if _, ok := ar.Criteria["unscored"]; ok {
+		t.Error("a criterion nobody scored was counted")
+	}
+	// quality's mean of 4 is 0.75 of 1-5 and fit's 1 is 0 of 1-3, the
+	// unscored criterion's weight is left out: (3*0.75 + 1*0) / 4
+	if !near(ar.Weighted, 0.5625) {
+		t.Errorf("Weighted = %v, want 0.5625", ar.Weighted)
+	}
+
+	empty := scored("empty", nil)
+	if ar := rankAbstract(&empty, rubric, NormRaw); ar.Weighted != 0 || ar.Reviews != 0 || len(ar.Criteria) != 0 {
+		t.Errorf("rankAbstract with no scores = %+v", ar)
+	}
+}
+
+func TestRankAbstracts(t *testing.T) {
+	rubric := Rubric{{Name: "q", Min: 1, Max: 3, Weight: 1}}
+	alist := Abstracts{
+		scored("unscored", nil),
+		scored("lucky", CriteriaScores{"q": Scores{"r1": 3}}),
+		scored("b agreed", CriteriaScores{"q": Scores{"r1": 3, "r2": 3}}),
+		scored("a agreed", CriteriaScores{"q": Scores{"r1": 3, "r2": 3}}),
+		scored("middling", CriteriaScores{"q": Scores{"r1": 2, "r2": 2}}),
+	}
+
+	// same weighted total goes to more reviews, then title
+	want := []string{"a agreed", "b agreed", "lucky", "middling", "unscored"}
+	ranking := rankAbstracts(alist, rubric, NormRaw)
+	for i, ar := range ranking {
+		if ar.Title != want[i] || ar.Rank != i+1 {
+			t.Errorf("rank %d = %d %s, want %s", i+1, ar.Rank, ar.Title, want[i])
+		}
+	}
+}
+
+func TestRankingHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	ms.SaveCriterion(&Criterion{Event: eventFlag, Name: "q", Min: 1, Max: 5, Weight: 1})
+	low, high := gocql.TimeUUID(), gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: low, Title: "low"})
+	ms.SaveAbstract(&Abstract{Id: high, Title: "high"})
+	ms.SaveScore(&ScoreUpdate{Id: low, Slot: "q", Email: "r1", Score: 2})
+	ms.SaveScore(&ScoreUpdate{Id: high, Slot: "q", Email: "r1", Score: 5})
+
+	rec := do(t, newRouter(), loginAs(t, "rev@x"), "GET", "/ranking/", "")
+	if rec.Code != 200 {
+		t.Fatalf("GET /ranking/ = %d %s", rec.Code, rec.Body.String())
+	}
+	ranking := Ranking{}
+	json.Unmarshal(rec.Body.Bytes(), &ranking)
+	if len(ranking) != 2 || ranking[0].Id != high || !near(ranking[0].Weighted, 1) || !near(ranking[1].Weighted, 0.25) {
+		t.Errorf("GET /ranking/ = %s", rec.Body.String())
+	}
+}
+
+func TestZScore(t *testing.T) {
+	tests := []struct {
+		score float64
+		stats CriterionStats
+		want  float64
+	}{
+		{5, CriterionStats{Mean: 3, StdDev: 2}, 1},
+		{1, CriterionStats{Mean: 3, StdDev: 2}, -1},
+		{3, CriterionStats{Mean: 3, StdDev: 2}, 0},
+		{4, CriterionStats{Mean: 4, StdDev: 0}, 0}, // gives everything the same
+	}
+
+	for _, tc := range tests {
+		if got := zscore(tc.score, tc.stats); !near(got, tc.want) {
+			t.Errorf("zscore(%v, %+v) = %v, want %v", tc.score, tc.stats, got, tc.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		score  float64
+		values []float64
+		want   float64
+	}{
+		{1, []float64{1, 2, 2, 3}, 0.125},
+		{2, []float64{1, 2, 2, 3}, 0.5}, // ties share the midpoint
+		{3, []float64{1, 2, 2, 3}, 0.875},
+		{4, []float64{4}, 0.5},
+		{5, []float64{5, 5, 5}, 0.5},
+		{1, []float64{1, 5, 5, 5, 5}, 0.1},
+	}
+
+	for _, tc := range tests {
+		if got := percentile(tc.score, tc.values); !near(got, tc.want) {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tc.score, tc.values, got, tc.want)
+		}
+	}
+}
+
+// g gives nearly everything 5 and h nearly everything 1, so h's 3 says
+// more than g's 5
+func calibrationAbstracts() Abstracts {
+	return Abstracts{
+		scored("x", CriteriaScores{"q": Scores{"g": 5}}),
+		scored("y", CriteriaScores{"q": Scores{"h": 3}}),
+		scored("w", CriteriaScores{"q": Scores{"g": 5}}),
+		scored("v", CriteriaScores{"q": Scores{"g": 4, "h": 1}}),
+		scored("u", CriteriaScores{"q": Scores{"h": 1}}),
+	}
+}
+
+func TestNormalizeScores(t *testing.T) {
+	rubric := Rubric{{Name: "q", Min: 1, Max: 5, Weight: 1}}
+	alist := calibrationAbstracts()
+
+	if raw := normalizeScores(alist, rubric, NormRaw); raw[0].Scores["q"]["g"] != 5 {
+		t.Errorf("raw changed the scores: %v", raw[0].Scores)
+	}
+
+	tests := []struct {
+		norm Normalization
+		x, y float64 // g's score on x, h's on y
+	}{
+		{NormZScore, math.Sqrt(0.5), math.Sqrt(2)},
+		{NormPercentile, 2.0 / 3, 2.5 / 3},
+	}
+	for _, tc := range tests {
+		out := normalizeScores(alist, rubric, tc.norm)
+		if x := float64(out[0].Scores["q"]["g"]); math.Abs(x-tc.x) > 1e-6 {
+			t.Errorf("%s: x = %v, want %v", tc.norm, x, tc.x)
+		}
+		if y := float64(out[1].Scores["q"]["h"]); math.Abs(y-tc.y) > 1e-6 {
+			t.Errorf("%s: y = %v, want %v", tc.norm, y, tc.y)
+		}
+		if alist[0].Scores["q"]["g"] != 5 {
+			t.Fatalf("%s: normalizeScores changed its input", tc.norm)
+		}
+	}
+}
+
+func TestNormalizedRanking(t *testing.T) {
+	rubric := Rubric{{Name: "q", Min: 1, Max: 5, Weight: 1}}
+
+	position := func(ranking Ranking, title string) int {
+		for _, ar := range ranking {
+			if ar.Title == title {
+				return ar.Rank
+			}
+		}
+		return 0
+	}
+
+	for _, tc := range []struct {
+		norm   Normalization
+		yFirst bool
+	}{
+		{NormRaw, false},
+		{NormZScore, true},
+		{NormPercentile, true},
+	} {
+		ranking := rankAbstracts(calibrationAbstracts(), rubric, tc.norm)
+		x, y := position(ranking, "x"), position(ranking, "y")
+		if (y < x) != tc.yFirst {
+			t.Errorf("%s: x ranked %d and y %d", tc.norm, x, y)
+		}
+	}
+}
+
+func TestRankingNormalizeParam(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	h, c := newRouter(), loginAs(t, "rev@x")
+
+	for param, code := range map[string]int{"": 200, "raw": 200, "zscore": 200, "percentile": 200, "bogus": 400} {
+		if rec := do(t, h, c, "GET", "/ranking/?normalize="+param, ""); rec.Code != code {
+			t.Errorf("normalize=%s = %d, want %d", param, rec.Code, code)
+		}
+	}
+}
+
+func TestDefaultRubricWeights(t *testing.T) {
+	rubric := defaultRubric("cfp")
+	tests := []struct {
+		name   string
+		scores CriteriaScores
+		want   float64
+	}{
+		// skill 5 of 1-5 is 1, quality 3 is 0.5, relevance 1 is 0
+		{"weighted", CriteriaScores{"scores_b": Scores{"r": 5}, "scores_c": Scores{"r": 3}, "scores_d": Scores{"r": 1}}, 0.35 + 0.40*0.5},
+		{"the overall call doesn't count with them", CriteriaScores{"scores_a": Scores{"r": 1}, "scores_b": Scores{"r": 5}}, 1},
+		{"only the overall call", CriteriaScores{"scores_a": Scores{"r": 3, "s": 2}}, 0.75},
+	}
+
+	for _, tc := range tests {
+		a := scored(tc.name, tc.scores)
+		if got := rankAbstract(&a, rubric, NormRaw).Weighted; !near(got, tc.want) {
+			t.Errorf("%s: Weighted = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * reviewers.go: the reviewer allow-list, see roles.go for what they can do
+ *
+ */
+
+import (
+	"time"
+)
+
+type Reviewer struct {
+	Email   string    `json:"email"`
+	Added   time.Time `json:"added"`
+	Role    Role      `json:"role"`    // empty means reviewer
+	Company string    `json:"company"` // for detecting conflicts of interest
+	Tracks  []string  `json:"tracks"`  // what track leads can edit, everyone else's preferred tracks for assignments
+}
+
+// checks the allow-list: admins and reviewers can log in with
+// an emailed link, nobody else gets one
+func checkIfAllowed(email string) (bool, error) {
+	isAdmin, err := checkIfAdmin(email)
+	if err != nil || isAdmin {
+		return isAdmin, err
+	}
+
+	_, err = fetchReviewer(email)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fetchReviewer looks email up by its lowercased form, then falls back
+// to a scan for rows saved before emails were lowercased
+func fetchReviewer(email string) (Reviewer, error) {
+	email = normalizeEmail(email)
+	r, err := db.FetchReviewer(email)
+	if err != ErrNotFound {
+		return r, err
+	}
+
+	rlist, err := db.ListReviewers()
+	if err != nil {
+		return r, err
+	}
+	for _, r := range rlist {
+		if normalizeEmail(r.Email) == email {
+			return r, nil
+		}
+	}
+
+	return r, ErrNotFound
+}
@@ -0,0 +1,391 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * revisions.go: edit history for abstracts
+ *
+ * Every save through the app writes a revision holding all of the
+ * editable fields as they were after the save, who did it, and which
+ * fields changed. Abstracts that were imported before the history was
+ * kept get a revision of their original state, with no editor, the first
+ * time they're edited. Scores and status aren't part of revisions.
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Revision struct {
+	AbsId      gocql.UUID `json:"abstract_id"`
+	Id         gocql.UUID `json:"id"` // timeuuid
+	Created    time.Time  `json:"created"`
+	Editor     string     `json:"editor"`
+	Changed    []string   `json:"changed"` // fields that differ from the previous revision
+	UpstreamId int        `json:"upstream_id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Authors    Authors    `json:"authors"`
+	Company    string     `json:"company"`
+	JobTitle   string     `json:"jobtitle"`
+	Bio        string     `json:"bio"`
+	Tracks     string     `json:"tracks"`
+}
+
+type Revisions []Revision
+
+// sorts by time, timeuuids don't sort by time as bytes
+func (revs Revisions) sort() {
+	sort.SliceStable(revs, func(i, j int) bool {
+		return revs[i].Created.Before(revs[j].Created)
+	})
+}
+
+// one field in a diff between two revisions
+type FieldDiff struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// same names as the json fields on Abstract
+var revisionFields = []string{"upstream_id", "title", "body", "authors", "company", "jobtitle", "bio", "tracks"}
+
+// fields that say who the speaker is, see hideIdentity
+var identityFields = map[string]bool{"authors": true, "company": true, "jobtitle": true, "bio": true}
+
+func newRevision(a *Abstract, editor string) Revision {
+	rv := Revision{
+		AbsId:      a.Id,
+		Id:         gocql.TimeUUID(),
+		Editor:     editor,
+		UpstreamId: a.UpstreamId,
+		Title:      a.Title,
+		Body:       a.Body,
+		Authors:    Authors{},
+		Company:    a.Company,
+		JobTitle:   a.JobTitle,
+		Bio:        a.Bio,
+		Tracks:     a.Tracks,
+	}
+	for email, name := range a.Authors {
+		rv.Authors[email] = name
+	}
+	rv.Created = rv.Id.Time()
+	return rv
+}
+
+func (rv *Revision) fields() map[string]interface{} {
+	// no authors reads back from the store as nil
+	if rv.Authors == nil {
+		rv.Authors = Authors{}
+	}
+	return map[string]interface{}{
+		"upstream_id": rv.UpstreamId,
+		"title":       rv.Title,
+		"body":        rv.Body,
+		"authors":     rv.Authors,
+		"company":     rv.Company,
+		"jobtitle":    rv.JobTitle,
+		"bio":         rv.Bio,
+		"tracks":      rv.Tracks,
+	}
+}
+
+// apply copies the revision's fields onto a, leaving everything else alone
+func (rv *Revision) apply(a *Abstract) {
+	a.UpstreamId = rv.UpstreamId
+	a.Title = rv.Title
+	a.Body = rv.Body
+	a.Authors = Authors{}
+	for email, name := range rv.Authors {
+		a.Authors[email] = name
+	}
+	a.Company = rv.Company
+	a.JobTitle = rv.JobTitle
+	a.Bio = rv.Bio
+	a.Tracks = rv.Tracks
+}
+
+func (rv *Revision) hideIdentity() {
+	rv.Authors = Authors{}
+	rv.Company = ""
+	rv.JobTitle = ""
+	rv.Bio = ""
+}
+
+// diffRevisions lists the fields that differ, in revisionFields order
+func diffRevisions(from, to *Revision) []FieldDiff {
+	ff, tf := from.fields(), to.fields()
+	diffs := make([]FieldDiff, 0)
+	for _, name := range revisionFields {
+		if !reflect.DeepEqual(ff[name], tf[name]) {
+			diffs = append(diffs, FieldDiff{Field: name, From: ff[name], To: tf[name]})
+		}
+	}
+	return diffs
+}
+
+// saveAbstract is how the handlers save abstracts, old is the abstract
+// before the edit or nil for new ones. Edits only go through if old is
+// still the latest version, otherwise it returns ErrStale. Saves that
+// don't change anything don't get a revision.
+func saveAbstract(a, old *Abstract, editor string) error {
+	prev := Revision{Authors: Authors{}}
+	if old != nil {
+		revs, err := db.ListRevisions(a.Id)
+		if err != nil {
+			return err
+		}
+		if len(revs) > 0 {
+			prev = revs[len(revs)-1]
+		} else {
+			prev = newRevision(old, "")
+			if !old.Created.IsZero() {
+				prev.Id = gocql.UUIDFromTime(old.Created)
+				prev.Created = prev.Id.Time()
+			}
+			prev.Changed = []string{}
+			err = db.SaveRevision(&prev)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	if old == nil {
+		a.Version = 1
+		err = db.SaveAbstract(a)
+	} else {
+		err = db.UpdateAbstract(a, old.Version)
+	}
+	if err != nil {
+		return err
+	}
+	search.update(a)
+
+	rv := newRevision(a, editor)
+	rv.Changed = make([]string, 0)
+	for _, d := range diffRevisions(&prev, &rv) {
+		rv.Changed = append(rv.Changed, d.Field)
+	}
+	if len(rv.Changed) == 0 {
+		return nil
+	}
+
+	return db.SaveRevision(&rv)
+}
+
+// fetches the abstract and revision named in the URL, writing an error
+// and returning false if either is missing
+func revisionVars(w http.ResponseWriter, r *http.Request) (Abstract, Revision, bool) {
+	vars := mux.Vars(r)
+	a := Abstract{}
+	rv := Revision{}
+
+	id, err := gocql.ParseUUID(vars["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return a, rv, false
+	}
+	a, err = db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return a, rv, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch abstract: %s", err), 500)
+		return a, rv, false
+	}
+
+	if vars["rev"] == "" {
+		return a, rv, true
+	}
+	rv, ok := fetchRevision(w, id, vars["rev"])
+	return a, rv, ok
+}
+
+func fetchRevision(w http.ResponseWriter, absId gocql.UUID, revId string) (Revision, bool) {
+	id, err := gocql.ParseUUID(revId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse revision uuid: '%s'", err), 400)
+		return Revision{}, false
+	}
+	rv, err := db.FetchRevision(absId, id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("revision '%s' not found", id), http.StatusNotFound)
+		return rv, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch revision: %s", err), 500)
+		return rv, false
+	}
+	return rv, true
+}
+
+// GET lists an abstract's revisions, oldest first
+func RevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermEdit)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, _, ok := revisionVars(w, r)
+	if !ok {
+		return
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RevisionsHandler failed to load event settings: %s", err), 500)
+		return
+	}
+
+	revs, err := db.ListRevisions(a.Id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RevisionsHandler failed: %s", err), 500)
+		return
+	}
+	if ev.hidesIdentity(u) {
+		for i := range revs {
+			revs[i].hideIdentity()
+		}
+	}
+
+	jsonOut(w, r, revs)
+}
+
+// GET /abstracts/{id}/diff?from={rev}&to={rev} returns the fields that
+// changed between two revisions, to defaults to the latest one
+func RevisionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermEdit)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, _, ok := revisionVars(w, r)
+	if !ok {
+		return
+	}
+
+	from, ok := fetchRevision(w, a.Id, r.FormValue("from"))
+	if !ok {
+		return
+	}
+
+	var to Revision
+	if r.FormValue("to") != "" {
+		to, ok = fetchRevision(w, a.Id, r.FormValue("to"))
+		if !ok {
+			return
+		}
+	} else {
+		revs, err := db.ListRevisions(a.Id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("RevisionDiffHandler failed: %s", err), 500)
+			return
+		}
+		to = revs[len(revs)-1] // from exists so this can't be empty
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RevisionDiffHandler failed to load event settings: %s", err), 500)
+		return
+	}
+	blind := ev.hidesIdentity(u)
+
+	diffs := make([]FieldDiff, 0)
+	for _, d := range diffRevisions(&from, &to) {
+		if blind && identityFields[d.Field] {
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+
+	jsonOut(w, r, map[string]interface{}{
+		"abstract_id": a.Id,
+		"from":        from.Id,
+		"to":          to.Id,
+		"diff":        diffs,
+	})
+}
+
+// POST /abstracts/{id}/revisions/{rev}/restore puts the revision's fields
+// back on the abstract, which is recorded as a new revision
+func RestoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	old, rv, ok := revisionVars(w, r)
+	if !ok {
+		return
+	}
+
+	ts, err := loadTracks()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RestoreRevisionHandler failed to load tracks: %s", err), 500)
+		return
+	}
+
+	a := old
+	rv.apply(&a)
+	if gone := ts.gone(&a, &old); len(gone) > 0 {
+		http.Error(w, fmt.Sprintf("the revision is in tracks that no longer exist: %s", strings.Join(gone, ", ")), http.StatusConflict)
+		return
+	}
+	err = ts.resolve(&a, &old)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = saveAbstract(&a, &old, u.Email)
+	if err == ErrStale {
+		http.Error(w, "the abstract was changed by somebody else, try again", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("RestoreRevisionHandler failed: %s", err), 500)
+		return
+	}
+	log.Printf("RestoreRevisionHandler: '%s' restored %s to revision %s\n", u.Email, a.Id, rv.Id)
+
+	setETag(w, a.Version)
+	jsonOut(w, r, a)
+}
@@ -0,0 +1,248 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * roles.go: who is allowed to do what
+ *
+ * Admins come from the admins table, everybody else gets their role
+ * from the reviewers table. Logging in isn't enough to see anything,
+ * an account needs a role first.
+ *
+ *   admin       everything, including managing users
+ *   chair       read, score, comment, and edit any abstract
+ *   track_lead  read, score, comment, and edit abstracts in their tracks,
+ *               see tracks.go for which tracks those are
+ *   reviewer    read, score, and comment
+ *   observer    read only
+ *
+ */
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleNone      Role = ""
+	RoleAdmin     Role = "admin"
+	RoleChair     Role = "chair"
+	RoleTrackLead Role = "track_lead"
+	RoleReviewer  Role = "reviewer"
+	RoleObserver  Role = "observer"
+)
+
+type Perm string
+
+const (
+	PermRead  Perm = "read"  // view abstracts, scores, and comments
+	PermScore Perm = "score" // score and comment
+	PermEdit  Perm = "edit"  // create, edit, and delete abstracts
+	PermAdmin Perm = "admin" // manage admins and reviewers
+)
+
+var rolePerms = map[Role][]Perm{
+	RoleAdmin:     {PermRead, PermScore, PermEdit, PermAdmin},
+	RoleChair:     {PermRead, PermScore, PermEdit},
+	RoleTrackLead: {PermRead, PermScore, PermEdit},
+	RoleReviewer:  {PermRead, PermScore},
+	RoleObserver:  {PermRead},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePerms[r]
+	return ok
+}
+
+// the logged-in user making a request
+type User struct {
+	Email  string   `json:"email"`
+	Role   Role     `json:"role"`
+	Tracks []string `json:"tracks"` // only meaningful for track leads, names and ids
+}
+
+func (u *User) Can(p Perm) bool {
+	for _, rp := range rolePerms[u.Role] {
+		if rp == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) Perms() []Perm {
+	perms := rolePerms[u.Role]
+	if perms == nil {
+		return []Perm{}
+	}
+	return perms
+}
+
+// CanEdit checks edit permission on a specific abstract. Track leads are
+// limited to abstracts where every track is one of theirs. Abstracts
+// from before the tracks table only have names to go by.
+func (u *User) CanEdit(a *Abstract) bool {
+	if !u.Can(PermEdit) {
+		return false
+	}
+	if u.Role != RoleTrackLead {
+		return true
+	}
+
+	tracks := a.TrackIds
+	if len(tracks) == 0 {
+		tracks = splitTracks(a.Tracks)
+	}
+	if len(tracks) == 0 {
+		return false
+	}
+	for _, t := range tracks {
+		if !u.hasTrack(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) hasTrack(track string) bool {
+	for _, t := range u.Tracks {
+		if strings.EqualFold(strings.TrimSpace(t), track) {
+			return true
+		}
+	}
+	return false
+}
+
+// Abstract.Tracks is a comma-separated list
+func splitTracks(tracks string) []string {
+	out := make([]string, 0)
+	for _, t := range strings.Split(tracks, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// lookupUser figures out the role for an email address
+func lookupUser(email string) (*User, error) {
+	email = normalizeEmail(email)
+	u := &User{Email: email, Tracks: []string{}}
+
+	isAdmin, err := checkIfAdmin(email)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		u.Role = RoleAdmin
+		return u, nil
+	}
+
+	r, err := fetchReviewer(email)
+	if err == ErrNotFound {
+		return u, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	u.Role = r.Role
+	if u.Role == RoleNone {
+		// rows from before roles existed are reviewers
+		u.Role = RoleReviewer
+	}
+	if r.Tracks != nil {
+		u.Tracks = r.Tracks
+	}
+
+	if u.Role == RoleTrackLead {
+		err = u.addLeadTracks()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// addLeadTracks adds the tracks that list u as a lead, and the ids of
+// the ones already named in the reviewer record
+func (u *User) addLeadTracks() error {
+	ts, err := loadTracks()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range ts.sorted() {
+		if !t.isLead(u.Email) && !u.hasTrack(t.Name) && !u.hasTrack(t.Id) {
+			continue
+		}
+		for _, s := range []string{t.Id, t.Name} {
+			if !u.hasTrack(s) {
+				u.Tracks = append(u.Tracks, s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sessionUser returns the logged-in user, with an empty email for
+// anonymous requests. New sessions are saved so the cookie gets set.
+func sessionUser(w http.ResponseWriter, r *http.Request) (*User, error) {
+	sess, err := store.Get(r, sessCookie)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookie: %s", err)
+	}
+
+	if sess.IsNew {
+		log.Printf("Saving new session ID '%s'.\n", sess.ID)
+		sess.Save(r, w)
+	}
+
+	email, _ := sess.Values["email"].(string)
+	if email == "" {
+		return &User{Tracks: []string{}}, nil
+	}
+
+	return lookupUser(email)
+}
+
+// authorize returns the logged-in user if they have the permission,
+// otherwise it writes a 401/403 and returns nil
+func authorize(w http.ResponseWriter, r *http.Request, p Perm) *User {
+	u, err := sessionUser(w, r)
+	if err != nil {
+		log.Printf("authorize: %s\n", err)
+		http.Error(w, "failed to check authorization", http.StatusInternalServerError)
+		return nil
+	}
+
+	if u.Email == "" {
+		http.Error(w, "login required", http.StatusUnauthorized)
+		return nil
+	}
+
+	if !u.Can(p) {
+		http.Error(w, fmt.Sprintf("'%s' permission required", p), http.StatusForbidden)
+		return nil
+	}
+
+	return u
+}
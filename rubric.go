@@ -0,0 +1,240 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * rubric.go: the scoring criteria for an event
+ *
+ * The criterion name is what goes in the "slot" field of a ScoreUpdate
+ * and is the key in Abstract.Scores. Events that haven't set up a
+ * rubric get defaultRubric: the No/Maybe/Yes call app.js has always
+ * had, plus the weighted criteria from the old Abstract comment.
+ *
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+type Criterion struct {
+	Event       string            `json:"event"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Min         Score             `json:"min"`
+	Max         Score             `json:"max"`
+	Labels      map[string]string `json:"labels"` // optional, limits scores to these values, e.g. "1": "No"
+	Weight      float64           `json:"weight"` // relative weight for aggregate scores
+	Position    int               `json:"position"`
+}
+
+type Rubric []Criterion
+
+var criterionNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// scores_a is the 1/2/3 No/Maybe/Yes scale app.js has used since 2015.
+// It has no weight so the 35/40/25 split is the whole weighted total,
+// abstracts that only have scores_a still rank, see rankAbstract.
+func defaultRubric(event string) Rubric {
+	return Rubric{
+		{
+			Event:       event,
+			Name:        "scores_a",
+			Description: "Should this talk be accepted?",
+			Min:         1,
+			Max:         3,
+			Labels:      map[string]string{"1": "No", "2": "Maybe", "3": "Yes"},
+			Weight:      0,
+			Position:    0,
+		},
+		{Event: event, Name: "scores_b", Description: "Estimated skill level of the speaker", Min: 1, Max: 5, Weight: 0.35, Position: 1},
+		{Event: event, Name: "scores_c", Description: "Quality of the abstract", Min: 1, Max: 5, Weight: 0.40, Position: 2},
+		{Event: event, Name: "scores_d", Description: "Relevance of the topic", Min: 1, Max: 5, Weight: 0.25, Position: 3},
+	}
+}
+
+// fetchRubric returns the rubric for an event, falling back
+// to the default when none has been set up
+func fetchRubric(event string) (Rubric, error) {
+	rubric, err := db.ListRubric(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rubric) == 0 {
+		return defaultRubric(event), nil
+	}
+
+	rubric.sort()
+	return rubric, nil
+}
+
+func (rubric Rubric) sort() {
+	sort.SliceStable(rubric, func(i, j int) bool {
+		if rubric[i].Position == rubric[j].Position {
+			return rubric[i].Name < rubric[j].Name
+		}
+		return rubric[i].Position < rubric[j].Position
+	})
+}
+
+func (rubric Rubric) Get(name string) (Criterion, bool) {
+	for _, c := range rubric {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Criterion{}, false
+}
+
+func (c Criterion) copy() Criterion {
+	if c.Labels != nil {
+		labels := make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+		c.Labels = labels
+	}
+	return c
+}
+
+// Check makes sure a score update is for a criterion in the rubric
+// and the score is in range
+func (rubric Rubric) Check(su *ScoreUpdate) error {
+	c, ok := rubric.Get(su.Slot)
+	if !ok {
+		return fmt.Errorf("'%s' is not a scoring criterion", su.Slot)
+	}
+
+	return c.Check(su.Score)
+}
+
+func (c *Criterion) Check(score Score) error {
+	if len(c.Labels) > 0 {
+		for value := range c.Labels {
+			v, err := strconv.ParseFloat(value, 32)
+			if err == nil && Score(v) == score {
+				return nil
+			}
+		}
+		return fmt.Errorf("%g is not one of the allowed values for '%s'", score, c.Name)
+	}
+
+	if score < c.Min || score > c.Max {
+		return fmt.Errorf("%g is out of range for '%s', must be %g-%g", score, c.Name, c.Min, c.Max)
+	}
+
+	return nil
+}
+
+// Validate checks a criterion before it's saved
+func (c *Criterion) Validate() error {
+	if !criterionNameRe.MatchString(c.Name) {
+		return errors.New("criterion names must be 1-64 letters, numbers, - or _")
+	}
+	if c.Min > c.Max {
+		return errors.New("min must not be larger than max")
+	}
+	if c.Weight < 0 {
+		return errors.New("weight must not be negative")
+	}
+
+	for value := range c.Labels {
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("label value '%s' is not a number", value)
+		}
+		if Score(v) < c.Min || Score(v) > c.Max {
+			return fmt.Errorf("label value '%s' is outside of min/max", value)
+		}
+	}
+
+	return nil
+}
+
+// GET returns the rubric for the current event
+func RubricHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermRead) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rubric, err := fetchRubric(eventFlag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RubricHandler failed: %s", err), 500)
+		return
+	}
+
+	jsonOut(w, r, rubric)
+}
+
+// PUT creates or replaces a criterion, DELETE removes it
+// Once a criterion is saved the default rubric no longer applies, so
+// events that want to keep scores_a need to PUT it too.
+func CriterionHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+
+	switch r.Method {
+	case "PUT":
+		c := Criterion{}
+		err := json.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("CriterionHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		c.Event = eventFlag
+		c.Name = name
+		err = c.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = db.SaveCriterion(&c)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("CriterionHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("CriterionHandler: PUT %s/%s by '%s'\n", c.Event, c.Name, u.Email)
+		jsonOut(w, r, c)
+	case "DELETE":
+		err := db.DeleteCriterion(eventFlag, name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("CriterionHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("CriterionHandler: DELETE %s/%s by '%s'\n", eventFlag, name, u.Email)
+		jsonOut(w, r, map[string]string{"name": name})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
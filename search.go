@@ -0,0 +1,464 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * search.go: full-text search over abstracts
+ *
+ * The index lives in memory. It's built from ListAbstracts the first time
+ * somebody searches and kept current by the handlers that save, trash and
+ * restore abstracts, so edits made by another instance of the app only
+ * show up after a restart.
+ *
+ * Queries are words, "quoted phrases", and either of those prefixed with
+ * a field, e.g. compaction "data model" track:Operations. Every part has
+ * to match. Results are ranked by tf-idf with matches in the title and
+ * tracks counting for more than matches in the body.
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// indexed fields and how much a match in each is worth
+var searchFields = map[string]float64{
+	"title":   3,
+	"tracks":  2,
+	"authors": 2,
+	"company": 1.5,
+	"body":    1,
+	"bio":     1,
+}
+
+// what people are likely to type in a field filter
+var searchFieldAliases = map[string]string{
+	"track":   "tracks",
+	"author":  "authors",
+	"speaker": "authors",
+}
+
+type SearchResult struct {
+	Id      gocql.UUID `json:"id"`
+	Title   string     `json:"title"`
+	Tracks  string     `json:"tracks"`
+	Score   float64    `json:"score"`
+	Matched []string   `json:"matched"` // fields that matched
+}
+
+type searchDoc struct {
+	id     gocql.UUID
+	title  string
+	tracks string
+	fields map[string][]string // field -> tokens in order
+}
+
+type searchIndex struct {
+	mtx      sync.RWMutex
+	built    bool
+	docs     map[gocql.UUID]*searchDoc
+	postings map[string]map[gocql.UUID]bool // token -> docs containing it
+
+	// ListAbstracts runs outside mtx, saves made meanwhile may or may not
+	// be in what it returns, so they're queued here and replayed after it
+	buildMtx sync.Mutex
+	building bool
+	pending  map[gocql.UUID]*searchDoc // nil removes
+}
+
+// one part of a query, a word is a phrase of one token
+type searchClause struct {
+	field  string // empty for any field
+	tokens []string
+}
+
+var search = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     make(map[gocql.UUID]*searchDoc),
+		postings: make(map[string]map[gocql.UUID]bool),
+	}
+}
+
+// tokenize lowercases and splits on anything that isn't a letter or digit
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newSearchDoc(a *Abstract) *searchDoc {
+	names := make([]string, 0, len(a.Authors))
+	for _, name := range a.Authors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &searchDoc{
+		id:     a.Id,
+		title:  a.Title,
+		tracks: a.Tracks,
+		fields: map[string][]string{
+			"title":   tokenize(a.Title),
+			"body":    tokenize(a.Body),
+			"bio":     tokenize(a.Bio),
+			"company": tokenize(a.Company),
+			"tracks":  tokenize(a.Tracks),
+			"authors": tokenize(strings.Join(names, " ")),
+		},
+	}
+}
+
+// ensure builds the index if it hasn't been yet, only one build runs at
+// a time and the rest wait for it
+func (si *searchIndex) ensure() error {
+	si.mtx.RLock()
+	built := si.built
+	si.mtx.RUnlock()
+	if built {
+		return nil
+	}
+
+	si.buildMtx.Lock()
+	defer si.buildMtx.Unlock()
+
+	si.mtx.Lock()
+	if si.built {
+		si.mtx.Unlock()
+		return nil
+	}
+	si.building = true
+	si.pending = make(map[gocql.UUID]*searchDoc)
+	si.mtx.Unlock()
+
+	alist, err := db.ListAbstracts()
+
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+	si.building = false
+	if err != nil {
+		si.pending = nil
+		return err
+	}
+	for i := range alist {
+		si.add(newSearchDoc(&alist[i]))
+	}
+	for id, doc := range si.pending {
+		si.drop(id)
+		if doc != nil {
+			si.add(doc)
+		}
+	}
+	si.pending = nil
+	si.built = true
+
+	return nil
+}
+
+// update adds or replaces an abstract. Before the index is built this is
+// a no-op since the build will pick it up, during the build it's queued.
+// Trashed abstracts are saved when a track is renamed and stay out of
+// the index.
+func (si *searchIndex) update(a *Abstract) {
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+
+	var doc *searchDoc
+	if a.DeletedAt.IsZero() {
+		doc = newSearchDoc(a)
+	}
+
+	if si.building {
+		si.pending[a.Id] = doc
+		return
+	}
+	if !si.built {
+		return
+	}
+	si.drop(a.Id)
+	if doc != nil {
+		si.add(doc)
+	}
+}
+
+func (si *searchIndex) remove(id gocql.UUID) {
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+
+	if si.building {
+		si.pending[id] = nil
+		return
+	}
+	si.drop(id)
+}
+
+// callers must hold the lock
+func (si *searchIndex) add(doc *searchDoc) {
+	si.docs[doc.id] = doc
+	for _, tokens := range doc.fields {
+		for _, t := range tokens {
+			if si.postings[t] == nil {
+				si.postings[t] = make(map[gocql.UUID]bool)
+			}
+			si.postings[t][doc.id] = true
+		}
+	}
+}
+
+// callers must hold the lock
+func (si *searchIndex) drop(id gocql.UUID) {
+	doc, ok := si.docs[id]
+	if !ok {
+		return
+	}
+	for _, tokens := range doc.fields {
+		for _, t := range tokens {
+			delete(si.postings[t], id)
+			if len(si.postings[t]) == 0 {
+				delete(si.postings, t)
+			}
+		}
+	}
+	delete(si.docs, id)
+}
+
+// parseQuery splits a query into clauses, quotes group a phrase and a
+// field: prefix limits a word or phrase to that field
+func parseQuery(q string) ([]searchClause, error) {
+	clauses := make([]searchClause, 0)
+	rs := []rune(q)
+
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		// an optional field name up to the colon
+		field := ""
+		j := i
+		for j < len(rs) && (unicode.IsLetter(rs[j]) || rs[j] == '_') {
+			j++
+		}
+		if j > i && j < len(rs) && rs[j] == ':' {
+			field = strings.ToLower(string(rs[i:j]))
+			if alias, ok := searchFieldAliases[field]; ok {
+				field = alias
+			}
+			if _, ok := searchFields[field]; !ok {
+				return nil, fmt.Errorf("unknown search field '%s'", field)
+			}
+			i = j + 1
+		}
+
+		var text string
+		if i < len(rs) && rs[i] == '"' {
+			end := i + 1
+			for end < len(rs) && rs[end] != '"' {
+				end++
+			}
+			text = string(rs[i+1 : end])
+			i = end + 1
+		} else {
+			end := i
+			for end < len(rs) && !unicode.IsSpace(rs[end]) {
+				end++
+			}
+			text = string(rs[i:end])
+			i = end
+		}
+
+		// punctuation like read-repair makes a phrase out of one word
+		tokens := tokenize(text)
+		if len(tokens) > 0 {
+			clauses = append(clauses, searchClause{field: field, tokens: tokens})
+		}
+	}
+
+	return clauses, nil
+}
+
+// occurrences counts how many times the phrase appears in tokens
+func occurrences(tokens, phrase []string) int {
+	n := 0
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j := range phrase {
+			if tokens[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+// query runs the clauses, fields not in allowed are never matched
+func (si *searchIndex) query(clauses []searchClause, allowed map[string]bool) []SearchResult {
+	si.mtx.RLock()
+	defer si.mtx.RUnlock()
+
+	results := make([]SearchResult, 0)
+	if len(clauses) == 0 {
+		return results
+	}
+
+	// candidates have every token somewhere, the postings don't know
+	// about fields or positions so they get checked below
+	var candidates map[gocql.UUID]bool
+	for _, c := range clauses {
+		for _, t := range c.tokens {
+			next := make(map[gocql.UUID]bool)
+			for id := range si.postings[t] {
+				if candidates == nil || candidates[id] {
+					next[id] = true
+				}
+			}
+			candidates = next
+		}
+	}
+
+	total := float64(len(si.docs))
+	idf := func(t string) float64 {
+		return math.Log(1 + total/float64(len(si.postings[t])))
+	}
+
+	for id := range candidates {
+		doc := si.docs[id]
+		score := 0.0
+		matched := make(map[string]bool)
+		ok := true
+
+		for _, c := range clauses {
+			weight := 0.0
+			for _, t := range c.tokens {
+				weight += idf(t)
+			}
+
+			found := false
+			for field, boost := range searchFields {
+				if !allowed[field] || (c.field != "" && c.field != field) {
+					continue
+				}
+				n := occurrences(doc.fields[field], c.tokens)
+				if n > 0 {
+					found = true
+					matched[field] = true
+					score += boost * weight * (1 + math.Log(float64(n)))
+				}
+			}
+			if !found {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		fields := make([]string, 0, len(matched))
+		for f := range matched {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		results = append(results, SearchResult{Id: id, Title: doc.title, Tracks: doc.tracks, Score: score, Matched: fields})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+
+	return results
+}
+
+// GET /search/?q=compaction+track:Operations&limit=20
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	clauses, err := parseQuery(r.FormValue("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if l := r.FormValue("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit '%s'", l), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("SearchHandler failed to load event settings: %s", err), 500)
+		return
+	}
+
+	// during blind review, finding an abstract by its speaker would say
+	// who the speaker is
+	allowed := make(map[string]bool)
+	for field := range searchFields {
+		allowed[field] = true
+	}
+	if ev.hidesIdentity(u) {
+		for field := range identityFields {
+			allowed[field] = false
+		}
+		for _, c := range clauses {
+			if identityFields[c.field] {
+				http.Error(w, fmt.Sprintf("can't search %s during blind review", c.field), http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	err = search.ensure()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("SearchHandler failed to build the index: %s", err), 500)
+		return
+	}
+
+	results := search.query(clauses, allowed)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	jsonOut(w, r, results)
+}
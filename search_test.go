@@ -0,0 +1,225 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * search_test.go: query parsing, phrase matching and the index
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func allFields() map[string]bool {
+	allowed := make(map[string]bool)
+	for f := range searchFields {
+		allowed[f] = true
+	}
+	return allowed
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string // fmt of the clauses
+		err  bool
+	}{
+		{"", "[]", false},
+		{"   ", "[]", false},
+		{"Compaction", "[{ [compaction]}]", false},
+		{"compaction repair", "[{ [compaction]} { [repair]}]", false},
+		{`"data model"`, "[{ [data model]}]", false},
+		{`"data model`, "[{ [data model]}]", false}, // unterminated runs to the end
+		{"read-repair", "[{ [read repair]}]", false},
+		{"title:compaction", "[{title [compaction]}]", false},
+		{`Track:"Data Modeling"`, "[{tracks [data modeling]}]", false},
+		{"speaker:smith", "[{authors [smith]}]", false},
+		{"title:", "[]", false},
+		{"-- !!", "[]", false},
+		{"nope:compaction", "", true},
+	}
+
+	for _, tc := range tests {
+		clauses, err := parseQuery(tc.q)
+		if (err != nil) != tc.err {
+			t.Errorf("parseQuery(%q) error = %v", tc.q, err)
+		} else if err == nil && fmt.Sprint(clauses) != tc.want {
+			t.Errorf("parseQuery(%q) = %v, want %s", tc.q, clauses, tc.want)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	tokens := tokenize("the data model is the data model of the model")
+	tests := []struct {
+		phrase string
+		want   int
+	}{
+		{"model", 3},
+		{"data model", 2},
+		{"the data model", 2},
+		{"model data", 0},
+		{"the model", 1},
+		{"of the model is", 0}, // runs off the end
+	}
+
+	for _, tc := range tests {
+		if got := occurrences(tokens, tokenize(tc.phrase)); got != tc.want {
+			t.Errorf("occurrences(%q) = %d, want %d", tc.phrase, got, tc.want)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	si := newSearchIndex()
+	docs := map[string]*Abstract{
+		"phrase":    {Title: "Data modeling", Body: "how to model your data", Tracks: "Development"},
+		"scattered": {Title: "Operations", Body: "data in one place and a model in another", Tracks: "Operations"},
+		"speaker":   {Title: "Repair", Body: "anti-entropy", Authors: Authors{"s@z": "Jane Model"}},
+	}
+	titles := make(map[gocql.UUID]string)
+	for name, a := range docs {
+		a.Id = gocql.TimeUUID()
+		titles[a.Id] = name
+		si.add(newSearchDoc(a))
+	}
+	si.built = true
+
+	withoutAuthors := allFields()
+	withoutAuthors["authors"] = false
+
+	tests := []struct {
+		q       string
+		allowed map[string]bool
+		want    []string
+	}{
+		{`"model your data"`, allFields(), []string{"phrase"}},
+		{`"data model"`, allFields(), []string{}},
+		{"data model", allFields(), []string{"phrase", "scattered"}},
+		{"track:operations", allFields(), []string{"scattered"}},
+		{"title:data body:model", allFields(), []string{"phrase"}},
+		{"author:model", allFields(), []string{"speaker"}},
+		{"author:model", withoutAuthors, []string{}},
+		{"anti-entropy", allFields(), []string{"speaker"}},
+		{"missing", allFields(), []string{}},
+	}
+
+	for _, tc := range tests {
+		clauses, err := parseQuery(tc.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := si.query(clauses, tc.allowed)
+		got := make([]string, 0, len(results))
+		for _, res := range results {
+			got = append(got, titles[res.Id])
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("query(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+
+	// a title match outranks the same word in the body
+	si.remove(docs["phrase"].Id)
+	clauses, _ := parseQuery("operations")
+	si.add(newSearchDoc(&Abstract{Id: gocql.TimeUUID(), Title: "Other", Body: "operations"}))
+	if results := si.query(clauses, allFields()); len(results) != 2 || results[0].Title != "Operations" {
+		t.Errorf("query(operations) = %+v", results)
+	}
+}
+
+// blockingListStore holds ListAbstracts open so saves can happen while
+// the index is being built
+type blockingListStore struct {
+	Store
+	listed, release chan struct{}
+}
+
+func (s *blockingListStore) ListAbstracts() (Abstracts, error) {
+	alist, err := s.Store.ListAbstracts()
+	close(s.listed)
+	<-s.release
+	return alist, err
+}
+
+func TestSearchBuildRace(t *testing.T) {
+	ms := setupMem(t)
+	old, added := Abstract{Id: gocql.TimeUUID(), Title: "compaction"}, Abstract{Id: gocql.TimeUUID(), Title: "compaction strategies"}
+	ms.SaveAbstract(&old)
+	bs := &blockingListStore{Store: ms, listed: make(chan struct{}), release: make(chan struct{})}
+	db = bs
+
+	si := newSearchIndex()
+	done := make(chan error)
+	go func() { done <- si.ensure() }()
+
+	// ListAbstracts has already read the old list
+	<-bs.listed
+	ms.SaveAbstract(&added)
+	si.update(&added)
+	si.remove(old.Id)
+	close(bs.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	clauses, _ := parseQuery("compaction")
+	if results := si.query(clauses, allFields()); len(results) != 1 || results[0].Id != added.Id {
+		t.Errorf("query after the build = %+v", results)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	ms.SaveAbstract(&Abstract{Id: gocql.TimeUUID(), Title: "Compaction", Authors: Authors{"s@z": "Jane Smith"}})
+	h, rev := newRouter(), loginAs(t, "rev@x")
+
+	tests := []struct {
+		q     string
+		code  int
+		found int
+	}{
+		{"compaction", 200, 1},
+		{"speaker:smith", 200, 1},
+		{"nope:x", 400, 0},
+	}
+	for _, tc := range tests {
+		rec := do(t, h, rev, "GET", "/search/?q="+tc.q, "")
+		results := []SearchResult{}
+		json.Unmarshal(rec.Body.Bytes(), &results)
+		if rec.Code != tc.code || len(results) != tc.found {
+			t.Errorf("GET /search/?q=%s = %d %s", tc.q, rec.Code, rec.Body.String())
+		}
+	}
+
+	// blind review keeps speakers out of reach, admins still see them
+	ms.SaveEvent(&Event{Name: eventFlag, Blind: true})
+	if rec := do(t, h, rev, "GET", "/search/?q=speaker:smith", ""); rec.Code != 403 {
+		t.Errorf("blind speaker: search = %d, want 403", rec.Code)
+	}
+	if rec := do(t, h, rev, "GET", "/search/?q=smith", ""); rec.Code != 200 || rec.Body.String() != "[]" {
+		t.Errorf("blind search for a speaker's name = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(t, h, loginAs(t, "adm@x"), "GET", "/search/?q=speaker:smith", ""); rec.Code != 200 {
+		t.Errorf("admin speaker: search during blind review = %d", rec.Code)
+	}
+}
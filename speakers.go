@@ -0,0 +1,339 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * speakers.go: speaker profiles shared by all of a speaker's abstracts
+ *
+ * A speaker's id is their email, the same one used as the key in
+ * Abstract.Authors, so the authors map is what links an abstract to its
+ * speakers. The bio, company and jobtitle on an abstract are what was
+ * submitted with it. New abstracts create a profile for any author that
+ * doesn't have one, after that the profile is edited on its own.
+ *
+ * Everything here is speaker identity, so none of it is shown to users
+ * the event is hiding identities from.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Speaker struct {
+	Email    Email             `json:"email"`
+	Name     string            `json:"name"`
+	Bio      string            `json:"bio"`
+	Company  string            `json:"company"`
+	JobTitle string            `json:"jobtitle"`
+	Photo    string            `json:"photo"`  // link to a headshot
+	Social   map[string]string `json:"social"` // e.g. "twitter": "@AlTobey"
+	Updated  time.Time         `json:"updated"`
+}
+
+// one of a speaker's abstracts, for "other submissions by this speaker"
+type SpeakerSubmission struct {
+	Id       gocql.UUID `json:"id"`
+	Title    string     `json:"title"`
+	Tracks   string     `json:"tracks"`
+	Status   Status     `json:"status"`
+	Speakers []Email    `json:"speakers"` // which of the speakers it shares
+}
+
+// GET /abstracts/{id} is the abstract with these added
+type AbstractDetail struct {
+	Abstract
+	Speakers         []Speaker           `json:"speakers"`
+	OtherSubmissions []SpeakerSubmission `json:"other_submissions"`
+}
+
+// GET /speakers/{email} is the profile with these added
+type SpeakerDetail struct {
+	Speaker
+	Submissions []SpeakerSubmission `json:"submissions"`
+}
+
+func speakerEmail(s string) Email {
+	return Email(strings.ToLower(strings.TrimSpace(s)))
+}
+
+func (s *Speaker) Validate() error {
+	s.Email = speakerEmail(string(s.Email))
+	if !strings.Contains(string(s.Email), "@") {
+		return fmt.Errorf("invalid speaker email '%s'", s.Email)
+	}
+	if strings.TrimSpace(s.Name) == "" {
+		return fmt.Errorf("speaker name is required")
+	}
+	if s.Photo != "" {
+		u, err := url.Parse(s.Photo)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("photo must be an http or https link")
+		}
+	}
+	for k := range s.Social {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("social handles need a name, e.g. twitter")
+		}
+	}
+	return nil
+}
+
+func (s Speaker) copy() Speaker {
+	if s.Social != nil {
+		social := make(map[string]string, len(s.Social))
+		for k, v := range s.Social {
+			social[k] = v
+		}
+		s.Social = social
+	}
+	return s
+}
+
+// speakerEmails is the abstract's authors in a stable order
+func (a *Abstract) speakerEmails() []Email {
+	emails := make([]Email, 0, len(a.Authors))
+	for email := range a.Authors {
+		emails = append(emails, speakerEmail(string(email)))
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i] < emails[j] })
+	return emails
+}
+
+// speakerFromAbstract is what a profile starts as. The abstract's bio,
+// company and jobtitle only belong to a speaker when there's one.
+func speakerFromAbstract(a *Abstract, email Email) Speaker {
+	s := Speaker{Email: email, Updated: time.Now()}
+	for e, name := range a.Authors {
+		if speakerEmail(string(e)) == email {
+			s.Name = name
+		}
+	}
+	if len(a.Authors) == 1 {
+		s.Bio, s.Company, s.JobTitle = a.Bio, a.Company, a.JobTitle
+	}
+	return s
+}
+
+// ensureSpeakers creates profiles for a new abstract's authors
+func ensureSpeakers(a *Abstract) error {
+	for _, email := range a.speakerEmails() {
+		_, err := db.FetchSpeaker(email)
+		if err == nil {
+			continue
+		} else if err != ErrNotFound {
+			return err
+		}
+
+		s := speakerFromAbstract(a, email)
+		err = db.SaveSpeaker(&s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// speakersFor fetches the abstract's speakers, authors without a profile
+// get one made up from the abstract
+func speakersFor(a *Abstract) ([]Speaker, error) {
+	slist := make([]Speaker, 0, len(a.Authors))
+	for _, email := range a.speakerEmails() {
+		s, err := db.FetchSpeaker(email)
+		if err == ErrNotFound {
+			s = speakerFromAbstract(a, email)
+		} else if err != nil {
+			return nil, err
+		}
+		slist = append(slist, s)
+	}
+	return slist, nil
+}
+
+// submissionsBy finds the abstracts by any of emails other than skip
+func submissionsBy(emails []Email, skip gocql.UUID) ([]SpeakerSubmission, error) {
+	want := make(map[Email]bool, len(emails))
+	for _, e := range emails {
+		want[e] = true
+	}
+
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(alist, func(i, j int) bool { return alist[i].Created.Before(alist[j].Created) })
+
+	subs := make([]SpeakerSubmission, 0)
+	for i := range alist {
+		a := &alist[i]
+		if a.Id == skip {
+			continue
+		}
+		shared := make([]Email, 0)
+		for _, e := range a.speakerEmails() {
+			if want[e] {
+				shared = append(shared, e)
+			}
+		}
+		if len(shared) > 0 {
+			subs = append(subs, SpeakerSubmission{Id: a.Id, Title: a.Title, Tracks: a.Tracks, Status: a.Status, Speakers: shared})
+		}
+	}
+
+	return subs, nil
+}
+
+// abstractDetail adds the speakers and their other submissions to a,
+// which should already have been hidden from u as needed
+func abstractDetail(a *Abstract, blind bool) (AbstractDetail, error) {
+	d := AbstractDetail{Abstract: *a, Speakers: []Speaker{}, OtherSubmissions: []SpeakerSubmission{}}
+	if blind {
+		return d, nil
+	}
+
+	var err error
+	d.Speakers, err = speakersFor(a)
+	if err != nil {
+		return d, err
+	}
+	d.OtherSubmissions, err = submissionsBy(a.speakerEmails(), a.Id)
+	return d, err
+}
+
+// speakerAccess authorizes u for p and checks they can see speakers
+func speakerAccess(w http.ResponseWriter, r *http.Request, p Perm) *User {
+	u := authorize(w, r, p)
+	if u == nil {
+		return nil
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to load event settings: %s", err), 500)
+		return nil
+	}
+	if ev.hidesIdentity(u) {
+		http.Error(w, "speakers are hidden during blind review", http.StatusForbidden)
+		return nil
+	}
+
+	return u
+}
+
+// GET lists every speaker profile
+func SpeakersHandler(w http.ResponseWriter, r *http.Request) {
+	if speakerAccess(w, r, PermRead) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	slist, err := db.ListSpeakers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("SpeakersHandler failed: %s", err), 500)
+		return
+	}
+	sort.Slice(slist, func(i, j int) bool {
+		return strings.ToLower(slist[i].Name) < strings.ToLower(slist[j].Name)
+	})
+
+	jsonOut(w, r, slist)
+}
+
+// GET a profile with all of the speaker's submissions, PUT adds or
+// replaces it (edit), DELETE removes it (admin)
+func SpeakerHandler(w http.ResponseWriter, r *http.Request) {
+	u := speakerAccess(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	email := speakerEmail(mux.Vars(r)["email"])
+
+	switch r.Method {
+	case "GET":
+		s, err := db.FetchSpeaker(email)
+		if err == ErrNotFound {
+			http.Error(w, fmt.Sprintf("speaker '%s' not found", email), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("SpeakerHandler failed: %s", err), 500)
+			return
+		}
+
+		subs, err := submissionsBy([]Email{email}, gocql.UUID{})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("SpeakerHandler failed: %s", err), 500)
+			return
+		}
+
+		jsonOut(w, r, SpeakerDetail{Speaker: s, Submissions: subs})
+	case "PUT":
+		if !u.Can(PermEdit) {
+			http.Error(w, "'edit' permission required", http.StatusForbidden)
+			return
+		}
+
+		s := Speaker{}
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("SpeakerHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+			return
+		}
+		s.Email = email
+		s.Updated = time.Now()
+
+		err = s.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = db.SaveSpeaker(&s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("SpeakerHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("SpeakerHandler: PUT %s by '%s'\n", s.Email, u.Email)
+		jsonOut(w, r, s)
+	case "DELETE":
+		if !u.Can(PermAdmin) {
+			http.Error(w, "'admin' permission required", http.StatusForbidden)
+			return
+		}
+
+		err := db.DeleteSpeaker(email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("SpeakerHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("SpeakerHandler: DELETE %s by '%s'\n", email, u.Email)
+		jsonOut(w, r, map[string]Email{"email": email})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * status.go: the decision workflow for abstracts
+ *
+ *   submitted -> under_review -> accepted   -> confirmed
+ *                             -> waitlisted -> accepted/rejected
+ *                             -> rejected
+ *
+ * Decisions can be sent back to under_review if the committee changes
+ * its mind, and anything that isn't rejected can be withdrawn. Status
+ * is only changed through StatusHandler, never by saving the abstract.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// from -> allowed to
+var statusTransitions = map[Status][]Status{
+	StatusSubmitted:   {StatusUnderReview, StatusWithdrawn},
+	StatusUnderReview: {StatusAccepted, StatusWaitlisted, StatusRejected, StatusWithdrawn},
+	StatusAccepted:    {StatusConfirmed, StatusUnderReview, StatusWithdrawn},
+	StatusWaitlisted:  {StatusAccepted, StatusRejected, StatusUnderReview, StatusWithdrawn},
+	StatusRejected:    {StatusUnderReview},
+	StatusConfirmed:   {StatusWithdrawn},
+	StatusWithdrawn:   {},
+}
+
+func (s Status) Valid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+func (s Status) CanMoveTo(to Status) bool {
+	for _, next := range statusTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// abstracts from before the workflow existed have no status
+func (a *Abstract) defaultStatus() {
+	if a.Status == "" {
+		a.Status = StatusSubmitted
+	}
+}
+
+// parseStatusFilter reads a comma-separated ?status= list, nil means
+// no filtering
+func parseStatusFilter(param string) (map[Status]bool, error) {
+	if param == "" {
+		return nil, nil
+	}
+
+	filter := make(map[Status]bool)
+	for _, s := range strings.Split(param, ",") {
+		status := Status(strings.TrimSpace(s))
+		if !status.Valid() {
+			return nil, fmt.Errorf("invalid status '%s'", status)
+		}
+		filter[status] = true
+	}
+
+	return filter, nil
+}
+
+// PUT moves an abstract to a new status, body: {"status": "accepted"}
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "PUT" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+
+	req := struct {
+		Status Status `json:"status"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("StatusHandler invalid json data: %s", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Status.Valid() {
+		http.Error(w, fmt.Sprintf("invalid status '%s'", req.Status), http.StatusBadRequest)
+		return
+	}
+
+	a, err := db.FetchAbstract(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("StatusHandler failed: %s", err), 500)
+		return
+	}
+
+	if !a.Status.CanMoveTo(req.Status) {
+		http.Error(w, fmt.Sprintf("can't go from '%s' to '%s'", a.Status, req.Status), http.StatusConflict)
+		return
+	}
+
+	from := a.Status
+	a.Status = req.Status
+	a.StatusBy = u.Email
+	a.StatusChanged = time.Now()
+	err = db.SaveStatus(&a)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("StatusHandler failed: %s", err), 500)
+		return
+	}
+	log.Printf("StatusHandler: '%s' moved %s from %s to %s\n", u.Email, id, from, a.Status)
+
+	jsonOut(w, r, map[string]interface{}{
+		"id":             a.Id,
+		"status":         a.Status,
+		"status_by":      a.StatusBy,
+		"status_changed": a.StatusChanged,
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * status_test.go: the decision workflow
+ *
+ */
+
+import (
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		ok       bool
+	}{
+		{StatusSubmitted, StatusUnderReview, true},
+		{StatusSubmitted, StatusAccepted, false}, // has to be reviewed first
+		{StatusSubmitted, StatusWithdrawn, true},
+		{StatusUnderReview, StatusAccepted, true},
+		{StatusUnderReview, StatusWaitlisted, true},
+		{StatusUnderReview, StatusRejected, true},
+		{StatusUnderReview, StatusConfirmed, false},
+		{StatusUnderReview, StatusSubmitted, false},
+		{StatusAccepted, StatusConfirmed, true},
+		{StatusAccepted, StatusUnderReview, true},
+		{StatusAccepted, StatusRejected, false},
+		{StatusWaitlisted, StatusAccepted, true},
+		{StatusWaitlisted, StatusRejected, true},
+		{StatusWaitlisted, StatusConfirmed, false},
+		{StatusRejected, StatusUnderReview, true},
+		{StatusRejected, StatusWithdrawn, false},
+		{StatusRejected, StatusAccepted, false},
+		{StatusConfirmed, StatusWithdrawn, true},
+		{StatusConfirmed, StatusUnderReview, false},
+		{StatusWithdrawn, StatusSubmitted, false},
+		{StatusWithdrawn, StatusUnderReview, false},
+		{StatusAccepted, StatusAccepted, false},
+		{"bogus", StatusUnderReview, false},
+	}
+
+	for _, tc := range tests {
+		if got := tc.from.CanMoveTo(tc.to); got != tc.ok {
+			t.Errorf("%s -> %s = %v, want %v", tc.from, tc.to, got, tc.ok)
+		}
+	}
+
+	// everything it can move to is a status too
+	for from, next := range statusTransitions {
+		for _, to := range next {
+			if !to.Valid() {
+				t.Errorf("%s -> %s isn't a valid status", from, to)
+			}
+		}
+	}
+}
+
+func TestParseStatusFilter(t *testing.T) {
+	tests := []struct {
+		param string
+		want  []Status
+		err   bool
+	}{
+		{"", nil, false},
+		{"accepted", []Status{StatusAccepted}, false},
+		{"submitted, rejected", []Status{StatusSubmitted, StatusRejected}, false},
+		{"accepted,bogus", nil, true},
+	}
+
+	for _, tc := range tests {
+		filter, err := parseStatusFilter(tc.param)
+		if (err != nil) != tc.err || len(filter) != len(tc.want) {
+			t.Errorf("parseStatusFilter(%q) = %v %v", tc.param, filter, err)
+			continue
+		}
+		for _, s := range tc.want {
+			if !filter[s] {
+				t.Errorf("parseStatusFilter(%q) is missing %s", tc.param, s)
+			}
+		}
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveAdmin("adm@x")
+	ms.SaveReviewer(&Reviewer{Email: "chair@x", Role: RoleChair})
+	id := gocql.TimeUUID()
+	ms.SaveAbstract(&Abstract{Id: id, Title: "t", Body: "b", Authors: Authors{"s@z": "S"}})
+	h, adm := newRouter(), loginAs(t, "adm@x")
+	path := "/abstracts/" + id.String() + "/status"
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"skip review", `{"status":"accepted"}`, 409},
+		{"unknown", `{"status":"bogus"}`, 400},
+		{"review", `{"status":"under_review"}`, 200},
+		{"accept", `{"status":"accepted"}`, 200},
+		{"accept again", `{"status":"accepted"}`, 409},
+	}
+	for _, tc := range tests {
+		if rec := do(t, h, adm, "PUT", path, tc.body); rec.Code != tc.code {
+			t.Errorf("%s: PUT %s = %d, want %d: %s", tc.name, path, rec.Code, tc.code, rec.Body.String())
+		}
+	}
+
+	if rec := do(t, h, loginAs(t, "chair@x"), "PUT", path, `{"status":"confirmed"}`); rec.Code != 403 {
+		t.Errorf("chair PUT %s = %d, want 403", path, rec.Code)
+	}
+	if rec := do(t, h, adm, "PUT", "/abstracts/"+gocql.TimeUUID().String()+"/status", `{"status":"withdrawn"}`); rec.Code != 404 {
+		t.Errorf("PUT status of a missing abstract = %d, want 404", rec.Code)
+	}
+
+	a, _ := ms.FetchAbstract(id)
+	if a.Status != StatusAccepted || a.StatusBy != "adm@x" || a.StatusChanged.IsZero() {
+		t.Errorf("abstract after the PUTs = %+v", a)
+	}
+}
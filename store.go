@@ -0,0 +1,196 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * store.go: the storage interface used by the handlers
+ *
+ * All data access goes through a Store so the handlers don't care
+ * whether the data lives in Cassandra or somewhere else.
+ *
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/gocql/gocql"
+	"sort"
+	"time"
+)
+
+// returned by the Fetch/Load methods when the record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// returned by conditional updates when somebody else got there first
+var ErrStale = errors.New("stale version")
+
+type Store interface {
+	ListAbstracts() (Abstracts, error)             // leaves out the trash
+	FetchAbstract(id gocql.UUID) (Abstract, error) // ErrNotFound if it's in the trash
+	// PageAbstracts returns up to size live abstracts starting at state,
+	// which is nil for the first page, and the state for the next page,
+	// nil after the last one. Pages may come back short.
+	PageAbstracts(size int, state []byte) (Abstracts, []byte, error)
+	ListTrash() (Abstracts, error)
+	FetchTrash(id gocql.UUID) (Abstract, error) // ErrNotFound if it isn't in the trash
+	SaveAbstract(a *Abstract) error
+	// UpdateAbstract only saves if the stored version is still version,
+	// returns ErrStale otherwise and sets a.Version to the new one
+	UpdateAbstract(a *Abstract, version int) error
+	SaveStatus(a *Abstract) error  // only writes the status fields
+	SaveDeleted(a *Abstract) error // only writes the deleted fields, zero values restore
+	// PurgeAbstract removes the abstract for good with its scores,
+	// comments, revisions, score audit, assignments and conflicts
+	PurgeAbstract(id gocql.UUID) error
+	SaveScore(su *ScoreUpdate) error // su must be checked against the rubric first
+
+	// tags are kept on the abstract and, in Cassandra, in an index by tag.
+	// The lists include abstracts in the trash.
+	AddTag(absId gocql.UUID, tag Tag) error
+	RemoveTag(absId gocql.UUID, tag Tag) error
+	ListTagged(tag Tag) ([]gocql.UUID, error)
+	ListTags() (map[Tag][]gocql.UUID, error)
+
+	SaveScoreAudit(sa *ScoreAudit) error
+	ListScoreAudits(absId gocql.UUID) (ScoreAudits, error)
+
+	ListRevisions(absId gocql.UUID) (Revisions, error) // oldest first
+	FetchRevision(absId, id gocql.UUID) (Revision, error)
+	SaveRevision(rv *Revision) error
+
+	FetchEvent(name string) (Event, error)
+	SaveEvent(e *Event) error
+
+	ListRubric(event string) (Rubric, error)
+	SaveCriterion(c *Criterion) error
+	DeleteCriterion(event, name string) error
+
+	ListTracks(event string) ([]Track, error)
+	SaveTrack(t *Track) error
+	DeleteTrack(event, id string) error
+
+	ListAssignments() (Assignments, error)
+	ListReviewerAssignments(email string) (Assignments, error)
+	SaveAssignment(as *Assignment) error
+	DeleteAssignment(email string, absId gocql.UUID) error
+
+	ListConflicts() (Conflicts, error)
+	FetchConflict(absId gocql.UUID, email string) (Conflict, error)
+	SaveConflict(c *Conflict) error
+	DeleteConflict(absId gocql.UUID, email string) error
+
+	ListComments(absId gocql.UUID) (Comments, error)
+	FetchComment(absId, id gocql.UUID) (Comment, error)
+	SaveComment(c *Comment) error
+
+	ListAdmins() (Admins, error)
+	SaveAdmin(email string) error
+	DeleteAdmin(email string) error
+
+	ListReviewers() ([]Reviewer, error)
+	FetchReviewer(email string) (Reviewer, error)
+	SaveReviewer(r *Reviewer) error
+	DeleteReviewer(email string) error
+
+	// speakers are keyed by the same email as Abstract.Authors, see speakers.go
+	ListSpeakers() ([]Speaker, error)
+	FetchSpeaker(email Email) (Speaker, error)
+	SaveSpeaker(s *Speaker) error
+	DeleteSpeaker(email Email) error
+
+	LoadSession(id string) (SessionRecord, error)
+	SaveSession(sr *SessionRecord, isNew bool) error
+	DeleteSession(id string) error
+
+	// tokens are single use, Consume deletes the token and
+	// returns ErrNotFound if it was already used
+	SaveLoginToken(lt *LoginToken) error
+	ConsumeLoginToken(id string) (LoginToken, error)
+}
+
+// abstracts.go is shared with the loaders, so methods that need a Store
+// live here instead
+func (scores ScoreUpdates) Save(s Store) (err error) {
+	for _, su := range scores {
+		err = s.SaveScore(&su)
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+// a session as it is stored in the backend, see cqlstore.go
+type SessionRecord struct {
+	Id       string
+	Email    string
+	Created  time.Time
+	Modified time.Time
+}
+
+func (a *Abstract) Deleted() bool {
+	return !a.DeletedAt.IsZero()
+}
+
+// inTrash makes a fetch of any abstract into a fetch from the trash or
+// from the live abstracts
+func inTrash(a Abstract, err error, trash bool) (Abstract, error) {
+	if err == nil && a.Deleted() != trash {
+		return Abstract{}, ErrNotFound
+	}
+	return a, err
+}
+
+func splitTrash(all Abstracts, trash bool) Abstracts {
+	alist := make(Abstracts, 0, len(all))
+	for _, a := range all {
+		if a.Deleted() == trash {
+			alist = append(alist, a)
+		}
+	}
+	return alist
+}
+
+// pageSorted pages through a full list for stores without a paging state
+// of their own. The state is the created time and id of the last
+// abstract on the previous page, so adds and deletes don't shift pages.
+func pageSorted(alist Abstracts, size int, state []byte) (Abstracts, []byte, error) {
+	key := func(a *Abstract) []byte {
+		k := make([]byte, 8, 24)
+		binary.BigEndian.PutUint64(k, uint64(a.Created.UnixNano()))
+		return append(k, a.Id.Bytes()...)
+	}
+
+	sort.Slice(alist, func(i, j int) bool {
+		return bytes.Compare(key(&alist[i]), key(&alist[j])) < 0
+	})
+
+	start := 0
+	if state != nil {
+		if len(state) != 24 {
+			return nil, nil, errors.New("invalid page state")
+		}
+		start = sort.Search(len(alist), func(i int) bool {
+			return bytes.Compare(key(&alist[i]), state) > 0
+		})
+	}
+
+	end := start + size
+	if end >= len(alist) {
+		return alist[start:], nil, nil
+	}
+	return alist[start:end], key(&alist[end-1]), nil
+}
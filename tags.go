@@ -0,0 +1,251 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * tags.go: free-form tags on abstracts
+ *
+ * Tags are added and removed one at a time rather than through PUT/PATCH
+ * so two reviewers tagging the same abstract don't step on each other or
+ * on an edit in progress.
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type TagCount struct {
+	Tag   Tag `json:"tag"`
+	Count int `json:"count"`
+}
+
+var tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9 _.+#-]{0,63}$`)
+
+// parseTag lowercases and trims, so "SSD " and "ssd" are the same tag
+func parseTag(s string) (Tag, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	if !tagRe.MatchString(t) {
+		return "", fmt.Errorf("invalid tag '%s': letters, digits, spaces and _.+#- only, up to 64 long", s)
+	}
+	return Tag(t), nil
+}
+
+// addTag returns tags with tag in it, sorted so sets read the same
+// from every store
+func addTag(tags []Tag, tag Tag) []Tag {
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	out := append(append([]Tag{}, tags...), tag)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func removeTag(tags []Tag, tag Tag) []Tag {
+	out := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		if t != tag {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// GET /tags/ returns every tag in use and how many abstracts have it
+func TagsHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermRead) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := db.ListTags()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TagsHandler failed: %s", err), 500)
+		return
+	}
+
+	// the tags are still on abstracts in the trash but they shouldn't count
+	trash, err := trashedIds()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TagsHandler failed: %s", err), 500)
+		return
+	}
+
+	out := make([]TagCount, 0, len(tags))
+	for tag, ids := range tags {
+		n := 0
+		for _, id := range ids {
+			if !trash[id] {
+				n++
+			}
+		}
+		if n > 0 {
+			out = append(out, TagCount{Tag: tag, Count: n})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Tag < out[j].Tag
+	})
+
+	jsonOut(w, r, out)
+}
+
+func trashedIds() (map[gocql.UUID]bool, error) {
+	alist, err := db.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[gocql.UUID]bool, len(alist))
+	for _, a := range alist {
+		ids[a.Id] = true
+	}
+	return ids, nil
+}
+
+// GET /tags/{tag} returns summaries of the abstracts with that tag
+func TagHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	tag, err := parseTag(mux.Vars(r)["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TagHandler failed to load event settings: %s", err), 500)
+		return
+	}
+	l, err := newLister(u, &ev)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TagHandler failed: %s", err), 500)
+		return
+	}
+
+	ids, err := db.ListTagged(tag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TagHandler failed: %s", err), 500)
+		return
+	}
+
+	out := make([]AbstractSummary, 0, len(ids))
+	for _, id := range ids {
+		// trashed abstracts aren't found, the index still has them
+		a, err := db.FetchAbstract(id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("TagHandler failed to fetch abstract: %s", err), 500)
+			return
+		}
+		it := l.item(a)
+		out = append(out, it.summary())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Created.Equal(out[j].Created) {
+			return out[i].Created.Before(out[j].Created)
+		}
+		return out[i].Id.String() < out[j].Id.String()
+	})
+
+	jsonOut(w, r, out)
+}
+
+// PUT or DELETE /abstracts/{id}/tags/{tag}, reviewers can tag anything
+// they aren't conflicted on
+func AbstractTagHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermScore)
+	if u == nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := gocql.ParseUUID(vars["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return
+	}
+	tag, err := parseTag(vars["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// recused also 404s for abstracts that don't exist
+	if !u.Can(PermAdmin) {
+		if recused(w, u.Email, id) {
+			return
+		}
+	} else if _, err := db.FetchAbstract(id); err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch abstract: %s", err), 500)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		err = db.AddTag(id, tag)
+	case "DELETE":
+		err = db.RemoveTag(id, tag)
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("AbstractTagHandler failed: %s", err), 500)
+		return
+	}
+	log.Printf("AbstractTagHandler: '%s' %s tag '%s' on %s\n", u.Email, r.Method, tag, id)
+
+	a, err := db.FetchAbstract(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("AbstractTagHandler failed to fetch abstract: %s", err), 500)
+		return
+	}
+	tags := a.Tags
+	if tags == nil {
+		tags = []Tag{}
+	}
+
+	jsonOut(w, r, map[string]interface{}{"id": id, "tags": tags})
+}
@@ -0,0 +1,602 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * tracks.go: the tracks of an event
+ *
+ * Abstracts point at tracks with Abstract.TrackIds. Abstract.Tracks, the
+ * comma-separated string everything used before, is kept as the names
+ * of those tracks so older clients and the CSV export still work. Either
+ * one can be sent on create or edit and the other is filled in: ids win
+ * if both changed, and names that aren't a track stay in the string.
+ *
+ * Track leads edit the abstracts in the tracks that list them as a lead,
+ * along with any named in their reviewer record.
+ *
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type Track struct {
+	Event       string   `json:"event"`
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Leads       []string `json:"leads"` // emails of the track leads
+	Slots       int      `json:"slots"` // how many talks the track has room for
+}
+
+// per-track numbers for the overview, see trackStats
+type TrackStats struct {
+	Id        string         `json:"id"`
+	Name      string         `json:"name"`
+	Slots     int            `json:"slots"`
+	Abstracts int            `json:"abstracts"`
+	Status    map[Status]int `json:"status"`
+	Accepted  int            `json:"accepted"`   // accepted or confirmed
+	Open      int            `json:"open"`       // slots left, negative when overbooked
+	Reviewed  int            `json:"reviewed"`   // abstracts with at least one review
+	Reviews   int            `json:"reviews"`    // reviewers times abstracts
+	MeanScore float64        `json:"mean_score"` // of the reviewed abstracts
+}
+
+// ids go in URLs, Validate also keeps "stats" free for /tracks/stats
+var trackIdRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// trackSlug makes an id out of a track name, "Internals & Theory"
+// becomes internals-theory
+func trackSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slug := strings.Join(words, "-")
+	if len(slug) > 64 {
+		slug = strings.TrimRight(slug[:64], "-")
+	}
+	return slug
+}
+
+func (t *Track) Validate() error {
+	if !trackIdRe.MatchString(t.Id) || t.Id == "stats" {
+		return fmt.Errorf("invalid track id '%s': lowercase letters, digits and - only", t.Id)
+	}
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("track name is required")
+	}
+	if t.Slots < 0 {
+		return fmt.Errorf("slots can't be negative")
+	}
+
+	leads := make([]string, 0, len(t.Leads))
+	for _, email := range t.Leads {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if !strings.Contains(email, "@") {
+			return fmt.Errorf("invalid lead email '%s'", email)
+		}
+		leads = append(leads, email)
+	}
+	sort.Strings(leads)
+	t.Leads = leads
+
+	return nil
+}
+
+func (t Track) copy() Track {
+	if t.Leads != nil {
+		t.Leads = append([]string{}, t.Leads...)
+	}
+	return t
+}
+
+func (t *Track) isLead(email string) bool {
+	for _, l := range t.Leads {
+		if strings.EqualFold(l, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackSet is the tracks of the current event by id
+type trackSet map[string]Track
+
+func loadTracks() (trackSet, error) {
+	tracks, err := db.ListTracks(eventFlag)
+	if err != nil {
+		return nil, err
+	}
+	ts := make(trackSet, len(tracks))
+	for _, t := range tracks {
+		ts[t.Id] = t
+	}
+	return ts, nil
+}
+
+// lookup finds a track by id or by name
+func (ts trackSet) lookup(s string) (Track, bool) {
+	if t, ok := ts[strings.ToLower(s)]; ok {
+		return t, true
+	}
+	for _, t := range ts {
+		if strings.EqualFold(t.Name, strings.TrimSpace(s)) {
+			return t, true
+		}
+	}
+	return Track{}, false
+}
+
+// sorted by name for lists and joined names
+func (ts trackSet) sorted() []Track {
+	out := make([]Track, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
+	})
+	return out
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve keeps TrackIds and Tracks in step, old is nil for a new
+// abstract. Ids that aren't a track are an error.
+func (ts trackSet) resolve(a, old *Abstract) error {
+	idsChanged := a.TrackIds != nil
+	namesChanged := true
+	if old != nil {
+		idsChanged = !sameStrings(a.TrackIds, old.TrackIds)
+		namesChanged = a.Tracks != old.Tracks
+	}
+
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+
+	if idsChanged {
+		for _, id := range a.TrackIds {
+			if _, ok := ts[id]; !ok {
+				return fmt.Errorf("unknown track '%s'", id)
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = ts[id].Name
+		}
+		a.Tracks = strings.Join(names, ", ")
+	} else if namesChanged {
+		for _, name := range splitTracks(a.Tracks) {
+			if t, ok := ts.lookup(name); ok && !seen[t.Id] {
+				seen[t.Id] = true
+				ids = append(ids, t.Id)
+			}
+		}
+		sort.Strings(ids)
+	} else {
+		return nil
+	}
+
+	a.TrackIds = ids
+	return nil
+}
+
+// resolveTracks loads the tracks and resolves a against them
+// gone lists the names in a's tracks that aren't a track and weren't
+// already on old, e.g. ones an old revision had before the track was
+// deleted. Events that haven't set up tracks have nothing to check.
+func (ts trackSet) gone(a, old *Abstract) []string {
+	out := make([]string, 0)
+	if len(ts) == 0 {
+		return out
+	}
+	had := make(map[string]bool)
+	for _, name := range splitTracks(old.Tracks) {
+		had[strings.ToLower(name)] = true
+	}
+	for _, name := range splitTracks(a.Tracks) {
+		if _, ok := ts.lookup(name); !ok && !had[strings.ToLower(name)] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// idsOf is the abstract's track ids, abstracts saved before there were
+// ids are matched by name
+func (ts trackSet) idsOf(a *Abstract) []string {
+	if len(a.TrackIds) > 0 {
+		return a.TrackIds
+	}
+	ids := make([]string, 0)
+	for _, name := range splitTracks(a.Tracks) {
+		if t, ok := ts.lookup(name); ok {
+			ids = append(ids, t.Id)
+		}
+	}
+	return ids
+}
+
+// trackAbstracts is every abstract using the track, trashed ones too
+// since they can be restored
+func (ts trackSet) trackAbstracts(id string) (Abstracts, error) {
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		return nil, err
+	}
+	trash, err := db.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(Abstracts, 0)
+	for _, a := range append(alist, trash...) {
+		for _, tid := range ts.idsOf(&a) {
+			if tid == id {
+				out = append(out, a)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// renameTrack rewrites the Tracks names of the abstracts using t before
+// t is saved under its new name. If one of them is edited at the same
+// time this stops with ErrStale, the abstracts already done have their
+// ids filled in so running it again picks up where it left off.
+func (ts trackSet) renameTrack(t *Track, editor string) (int, error) {
+	alist, err := ts.trackAbstracts(t.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([][]string, len(alist))
+	for i := range alist {
+		ids[i] = ts.idsOf(&alist[i])
+	}
+	ts[t.Id] = *t
+
+	for i := range alist {
+		a := alist[i].copy()
+		a.TrackIds = ids[i]
+		err = ts.resolve(&a, nil)
+		if err != nil {
+			return i, err
+		}
+		err = saveAbstract(&a, &alist[i], editor)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(alist), nil
+}
+
+func resolveTracks(a, old *Abstract) error {
+	ts, err := loadTracks()
+	if err != nil {
+		return err
+	}
+	return ts.resolve(a, old)
+}
+
+// GET lists the event's tracks, POST adds one with an id made from its
+// name unless it has one
+func TracksHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	ts, err := loadTracks()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TracksHandler failed: %s", err), 500)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		jsonOut(w, r, ts.sorted())
+	case "POST":
+		if !u.Can(PermAdmin) {
+			http.Error(w, "'admin' permission required", http.StatusForbidden)
+			return
+		}
+
+		t := Track{}
+		err := json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TracksHandler/POST invalid json data: %s", err), http.StatusBadRequest)
+			return
+		}
+		t.Event = eventFlag
+		if t.Id == "" {
+			t.Id = trackSlug(t.Name)
+		}
+
+		err = t.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := ts[t.Id]; ok {
+			http.Error(w, fmt.Sprintf("track '%s' already exists", t.Id), http.StatusConflict)
+			return
+		}
+
+		err = db.SaveTrack(&t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TracksHandler/POST failed: %s", err), 500)
+			return
+		}
+		log.Printf("TracksHandler: POST %s/%s by '%s'\n", t.Event, t.Id, u.Email)
+		jsonOut(w, r, t)
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// GET, PUT or DELETE /tracks/{id}. Admins can do anything, a track's
+// leads can change its description and slots.
+func TrackHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	ts, err := loadTracks()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrackHandler failed: %s", err), 500)
+		return
+	}
+	old, exists := ts[id]
+
+	switch r.Method {
+	case "GET":
+		if !exists {
+			http.Error(w, fmt.Sprintf("track '%s' not found", id), http.StatusNotFound)
+			return
+		}
+		jsonOut(w, r, old)
+	case "PUT":
+		t := Track{}
+		err := json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TrackHandler/PUT invalid json data: %s", err), http.StatusBadRequest)
+			return
+		}
+		t.Event = eventFlag
+		t.Id = id
+
+		if !u.Can(PermAdmin) {
+			if !exists || u.Role != RoleTrackLead || !old.isLead(u.Email) {
+				http.Error(w, "'admin' permission required", http.StatusForbidden)
+				return
+			}
+			// only admins decide who leads what
+			t.Name, t.Leads = old.Name, old.Leads
+		}
+
+		err = t.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the abstracts carry the name too
+		if exists && t.Name != old.Name {
+			n, err := ts.renameTrack(&t, u.Email)
+			if err == ErrStale {
+				http.Error(w, fmt.Sprintf("an abstract changed while renaming the track, %d were renamed, PUT again to finish", n), http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("TrackHandler/PUT failed to rename the abstracts' track: %s", err), 500)
+				return
+			}
+		}
+
+		err = db.SaveTrack(&t)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TrackHandler/PUT failed: %s", err), 500)
+			return
+		}
+		log.Printf("TrackHandler: PUT %s/%s by '%s'\n", t.Event, t.Id, u.Email)
+		jsonOut(w, r, t)
+	case "DELETE":
+		if !u.Can(PermAdmin) {
+			http.Error(w, "'admin' permission required", http.StatusForbidden)
+			return
+		}
+
+		// the abstracts would be left pointing at nothing, trashed ones
+		// couldn't be restored
+		alist, err := ts.trackAbstracts(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TrackHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		if len(alist) > 0 {
+			http.Error(w, fmt.Sprintf("track '%s' still has %d abstracts, including the trash", id, len(alist)), http.StatusConflict)
+			return
+		}
+
+		err = db.DeleteTrack(eventFlag, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("TrackHandler/DELETE failed: %s", err), 500)
+			return
+		}
+		log.Printf("TrackHandler: DELETE %s/%s by '%s'\n", eventFlag, id, u.Email)
+		jsonOut(w, r, map[string]string{"id": id})
+	default:
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /tracks/{id}/abstracts/ is GET /abstracts/?track={id}, and takes
+// the same parameters
+func TrackAbstractsHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ts, err := loadTracks()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrackAbstractsHandler failed: %s", err), 500)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if _, ok := ts[id]; !ok {
+		http.Error(w, fmt.Sprintf("track '%s' not found", id), http.StatusNotFound)
+		return
+	}
+
+	ev, err := fetchEvent()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load event settings: %s", err), 500)
+		return
+	}
+
+	r.ParseForm()
+	r.Form.Set("track", id)
+	listAbstracts(w, r, u, &ev)
+}
+
+// trackStats adds up every abstract into its tracks, scores are what u
+// is allowed to see
+func trackStats(u *User, ts trackSet) ([]TrackStats, error) {
+	ev, err := fetchEvent()
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLister(u, &ev)
+	if err != nil {
+		return nil, err
+	}
+	alist, err := db.ListAbstracts()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*TrackStats, len(ts))
+	out := make([]TrackStats, 0, len(ts))
+	for _, t := range ts.sorted() {
+		stats[t.Id] = &TrackStats{Id: t.Id, Name: t.Name, Slots: t.Slots, Status: make(map[Status]int)}
+	}
+	scores := make(map[string]float64)
+
+	for _, a := range alist {
+		it := l.item(a)
+		for _, id := range ts.idsOf(&a) {
+			st, ok := stats[id]
+			if !ok {
+				continue
+			}
+			st.Abstracts++
+			st.Status[it.a.Status]++
+			if it.a.Status == StatusAccepted || it.a.Status == StatusConfirmed {
+				st.Accepted++
+			}
+			if it.reviews > 0 {
+				st.Reviewed++
+				st.Reviews += it.reviews
+				scores[id] += it.score
+			}
+		}
+	}
+
+	for _, t := range ts.sorted() {
+		st := stats[t.Id]
+		st.Open = st.Slots - st.Accepted
+		if st.Reviewed > 0 {
+			st.MeanScore = scores[t.Id] / float64(st.Reviewed)
+		}
+		out = append(out, *st)
+	}
+
+	return out, nil
+}
+
+// GET /tracks/stats for every track or /tracks/{id}/stats for one
+func TrackStatsHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermRead)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ts, err := loadTracks()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrackStatsHandler failed: %s", err), 500)
+		return
+	}
+
+	id, one := mux.Vars(r)["id"]
+	if one {
+		t, ok := ts[id]
+		if !ok {
+			http.Error(w, fmt.Sprintf("track '%s' not found", id), http.StatusNotFound)
+			return
+		}
+		ts = trackSet{id: t}
+	}
+
+	stats, err := trackStats(u, ts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrackStatsHandler failed: %s", err), 500)
+		return
+	}
+
+	if one {
+		jsonOut(w, r, stats[0])
+		return
+	}
+	jsonOut(w, r, stats)
+}
@@ -0,0 +1,58 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * tracks_test.go: per-track stats
+ *
+ */
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestTrackStats(t *testing.T) {
+	ms := setupMem(t)
+	ms.SaveReviewer(&Reviewer{Email: "rev@x"})
+	ms.SaveTrack(&Track{Event: eventFlag, Id: "ops", Name: "Operations", Slots: 2})
+	ms.SaveTrack(&Track{Event: eventFlag, Id: "dev", Name: "Development", Slots: 1})
+
+	// older abstracts only have the names in tracks
+	ms.SaveAbstract(&Abstract{Id: gocql.TimeUUID(), Title: "ids", TrackIds: []string{"ops"}})
+	ms.SaveAbstract(&Abstract{Id: gocql.TimeUUID(), Title: "names", Tracks: "Operations, Development"})
+	ms.SaveAbstract(&Abstract{Id: gocql.TimeUUID(), Title: "unknown", Tracks: "Nope"})
+	h, rev := newRouter(), loginAs(t, "rev@x")
+
+	rec := do(t, h, rev, "GET", "/tracks/stats", "")
+	stats := []TrackStats{}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	got := make(map[string]int)
+	for _, st := range stats {
+		got[st.Id] = st.Abstracts
+	}
+	if rec.Code != 200 || len(stats) != 2 || got["ops"] != 2 || got["dev"] != 1 {
+		t.Errorf("GET /tracks/stats = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(t, h, rev, "GET", "/tracks/dev/stats", "")
+	one := TrackStats{}
+	json.Unmarshal(rec.Body.Bytes(), &one)
+	if rec.Code != 200 || one.Abstracts != 1 || one.Open != 1 {
+		t.Errorf("GET /tracks/dev/stats = %d %s", rec.Code, rec.Body.String())
+	}
+}
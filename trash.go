@@ -0,0 +1,131 @@
+package main
+
+/*
+ * Copyright 2014 Albert P. Tobey <atobey@datastax.com> @AlTobey
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * trash.go: deleted abstracts
+ *
+ * DELETE /abstracts/{id} only marks the abstract as deleted. It drops
+ * out of every list and fetch, but its scores, comments and history are
+ * kept until an admin restores it or purges it from the trash.
+ *
+ */
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"time"
+)
+
+// GET lists the trash, admin-only
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermAdmin) == nil {
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	alist, err := db.ListTrash()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrashHandler failed: %s", err), 500)
+		return
+	}
+
+	jsonOut(w, r, alist)
+}
+
+// fetches the deleted abstract named in the URL, writing an error and
+// returning false if it isn't in the trash
+func trashVars(w http.ResponseWriter, r *http.Request) (Abstract, bool) {
+	id, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse uuid: '%s'", err), 400)
+		return Abstract{}, false
+	}
+
+	a, err := db.FetchTrash(id)
+	if err == ErrNotFound {
+		http.Error(w, fmt.Sprintf("abstract '%s' is not in the trash", id), http.StatusNotFound)
+		return a, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch abstract: %s", err), 500)
+		return a, false
+	}
+
+	return a, true
+}
+
+// DELETE purges the abstract along with its scores, comments and history
+func TrashedAbstractHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "DELETE" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, ok := trashVars(w, r)
+	if !ok {
+		return
+	}
+
+	err := db.PurgeAbstract(a.Id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("TrashedAbstractHandler failed: %s", err), 500)
+		return
+	}
+	log.Printf("TrashedAbstractHandler: '%s' purged %s '%s'\n", u.Email, a.Id, a.Title)
+
+	jsonOut(w, r, map[string]interface{}{"id": a.Id, "purged": time.Now()})
+}
+
+// POST takes an abstract back out of the trash
+func RestoreAbstractHandler(w http.ResponseWriter, r *http.Request) {
+	u := authorize(w, r, PermAdmin)
+	if u == nil {
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("method '%s' not implemented", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, ok := trashVars(w, r)
+	if !ok {
+		return
+	}
+
+	a.DeletedBy = ""
+	a.DeletedAt = time.Time{}
+	err := db.SaveDeleted(&a)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("RestoreAbstractHandler failed: %s", err), 500)
+		return
+	}
+	search.update(&a)
+	log.Printf("RestoreAbstractHandler: '%s' restored %s from the trash\n", u.Email, a.Id)
+
+	jsonOut(w, r, a)
+}
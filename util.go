@@ -18,7 +18,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func jsonOut(w http.ResponseWriter, r *http.Request, data interface{}) {
@@ -29,3 +32,31 @@ func jsonOut(w http.ResponseWriter, r *http.Request, data interface{}) {
 	}
 	w.Write(js)
 }
+
+// abstracts use their version as the ETag
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// checkIfMatch writes an error and returns false unless the request has an
+// If-Match header for version, or "*"
+func checkIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		http.Error(w, "If-Match header with the abstract's ETag is required", http.StatusPreconditionRequired)
+		return false
+	}
+	if match == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.Trim(strings.TrimSpace(tag), `"`)
+		if v, err := strconv.Atoi(tag); err == nil && v == version {
+			return true
+		}
+	}
+
+	http.Error(w, "the abstract was changed by somebody else, reload it and try again", http.StatusPreconditionFailed)
+	return false
+}